@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Names used in `config.json` bindings
+const (
+	ACTION_PLAY_RANDOM        = "play_random"
+	ACTION_PLAY_SIMILAR       = "play_similar"        // tags like the current station
+	ACTION_PLAY_LIKE_FAVS     = "play_like_favorites" // tags like the favorites
+	ACTION_PLAY_NEARBY        = "play_nearby"         // near one of the places
+	ACTION_PLAY_FAVORITE      = "play_favorite"
+	ACTION_ADD_FAVORITE       = "add_favorite"
+	ACTION_REMOVE_FAVORITE    = "remove_favorite"
+	ACTION_NEXT_FAVORITE      = "next_favorite"
+	ACTION_PREV_FAVORITE      = "prev_favorite"
+	ACTION_STEP_FAVORITE      = "step_favorite" // by the encoder's steps
+	ACTION_MOVE_FAVORITE_UP   = "move_favorite_up"
+	ACTION_MOVE_FAVORITE_DOWN = "move_favorite_down"
+	ACTION_SWITCH_PROFILE     = "switch_profile"
+	ACTION_SHOW_STATS         = "show_stats"  // of the current station
+	ACTION_PLAY_PRESET        = "play_preset" // the dial's position
+	ACTION_IDENTIFY           = "identify"
+	ACTION_MUTE               = "mute"
+	ACTION_VOLUME             = "volume" // by the encoder's steps
+	ACTION_VOLUME_UP          = "volume_up"
+	ACTION_VOLUME_DOWN        = "volume_down"
+)
+
+// Action runs in response to a gesture. `ev.Value` carries the steps of a
+// TURN or the position of a DIAL.
+type Action func(ev InputEvent)
+
+// Actions is the registry of everything a binding can trigger
+type Actions map[string]Action
+
+func (actions Actions) Register(name string, action Action) {
+	actions[name] = action
+}
+
+func (actions Actions) Names() []string {
+	names := []string{}
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check makes sure every binding in `config` has a registered action
+func (actions Actions) Check(config Config) error {
+	for _, b := range config.Bindings {
+		if _, ok := actions[b.Action]; !ok {
+			return fmt.Errorf("unknown action `%s`, expected one of %v", b.Action, actions.Names())
+		}
+	}
+	return nil
+}
+
+// Dispatch runs the action `config` binds to `ev`, if any
+func (actions Actions) Dispatch(config Config, ev InputEvent) {
+	name, ok := config.Binding(ev)
+	if !ok {
+		return
+	}
+	action, ok := actions[name]
+	if !ok {
+		return
+	}
+	fmt.Printf("[INPUT] %s -> %s\n", ev, name)
+	action(ev)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	CATALOG_FILE = "catalog.json"
+	// Stations downloaded per language the first time
+	CATALOG_LIMIT = 1000
+	// Changed stations asked for at a time when bringing a language up to date
+	CATALOG_CHANGES_PAGE = 100
+	// A language older than MAX_AGE is brought up to date, checked every
+	// REFRESH_INTERVAL
+	CATALOG_MAX_AGE          = 24 * time.Hour
+	CATALOG_REFRESH_INTERVAL = 10 * time.Minute
+	// Random discovery tops the catalog up with a search at most this often
+	CATALOG_TOP_UP_INTERVAL = 10 * time.Minute
+
+	CATALOG *Catalog // nil until main loads it
+)
+
+// Catalog is a local copy of the radio-browser stations in our languages,
+// so random discovery answers without waiting on the API, and keeps working
+// when it is down. Each language starts with the most clicked stations and
+// grows with whatever searches turn up.
+type Catalog struct {
+	Path string
+
+	mu        sync.Mutex
+	stations  map[string][]Station // by language
+	refreshed map[string]time.Time // by language
+	dirty     bool                 // Added to since the last save
+	toppedUp  time.Time
+}
+
+// catalogChange is a station as /json/stations/search has it, with what we
+// need to bring the catalog up to date
+type catalogChange struct {
+	Station
+	LastChange  string `json:"lastchangetime_iso8601"`
+	LastCheckOK int    `json:"lastcheckok"`
+}
+
+type catalogFile struct {
+	Stations  map[string][]Station `json:"stations"`
+	Refreshed map[string]time.Time `json:"refreshed"`
+}
+
+func LoadCatalog(path string) *Catalog {
+	catalog := &Catalog{
+		Path:      path,
+		stations:  map[string][]Station{},
+		refreshed: map[string]time.Time{},
+	}
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return catalog
+	}
+	file := catalogFile{}
+	if err := json.Unmarshal(fileData, &file); err != nil {
+		fmt.Printf("[CATALOG] Ignoring %s: %s\n", path, err)
+		return catalog
+	}
+	if file.Stations != nil {
+		catalog.stations = file.Stations
+	}
+	if file.Refreshed != nil {
+		catalog.refreshed = file.Refreshed
+	}
+	return catalog
+}
+
+func (c *Catalog) save() error {
+	c.dirty = false
+	fileData, err := json.Marshal(catalogFile{Stations: c.stations, Refreshed: c.refreshed})
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(c.Path, fileData)
+}
+
+// Len is the number of stations across all languages
+func (c *Catalog) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, stations := range c.stations {
+		n += len(stations)
+	}
+	return n
+}
+
+// Languages are the languages the catalog has stations for
+func (c *Catalog) Languages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	languages := []string{}
+	for language := range c.stations {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Candidates are the stations in one of `languages` that pass `filters`,
+// other than `current`
+func (c *Catalog) Candidates(current Station, languages []string, filters SearchFilters) []Station {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	candidates := []Station{}
+	for _, language := range languages {
+		for _, station := range c.stations[language] {
+			if station.UUID != current.UUID && filters.Matches(station) {
+				candidates = append(candidates, station)
+			}
+		}
+	}
+	return candidates
+}
+
+// Random picks a station in one of `languages` that passes `filters`
+func (c *Catalog) Random(current Station, languages []string, filters SearchFilters) (Station, error) {
+	candidates := c.Candidates(current, languages, filters)
+	if len(candidates) == 0 {
+		return Station{}, errors.New("No matching stations in the catalog")
+	}
+	station, ok := RELIABILITY.Pick(candidates)
+	if !ok {
+		return Station{}, errors.New("Only unreliable stations in the catalog")
+	}
+	return station, nil
+}
+
+// Stale is the language most in need of a download, if any is older than
+// CATALOG_MAX_AGE
+func (c *Catalog) Stale(languages []string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stalest := ""
+	var oldest time.Time
+	for _, language := range languages {
+		refreshed, ok := c.refreshed[language]
+		if !ok {
+			return language, true
+		}
+		if stalest == "" || refreshed.Before(oldest) {
+			stalest, oldest = language, refreshed
+		}
+	}
+	return stalest, stalest != "" && time.Since(oldest) > CATALOG_MAX_AGE
+}
+
+// Refresh brings one language up to date and saves the catalog. The first
+// time the most clicked stations are downloaded, after that only the
+// stations changed since the last refresh.
+func (c *Catalog) Refresh(rb *RadioBrowser, language string) error {
+	c.mu.Lock()
+	since, ok := c.refreshed[language]
+	c.mu.Unlock()
+	started := time.Now()
+	if !ok {
+		return c.download(rb, language, started)
+	}
+
+	changed, removed := []Station{}, map[string]bool{}
+	for offset := 0; offset < CATALOG_LIMIT; offset += CATALOG_CHANGES_PAGE {
+		query := url.Values{}
+		query.Set("language", language)
+		query.Set("order", "changetimestamp")
+		query.Set("reverse", "true")
+		query.Set("offset", fmt.Sprint(offset))
+		query.Set("limit", fmt.Sprint(CATALOG_CHANGES_PAGE))
+		changes := []catalogChange{}
+		if err := rb.get("/json/stations/search", query, &changes); err != nil {
+			return err
+		}
+		done := len(changes) < CATALOG_CHANGES_PAGE
+		for _, change := range changes {
+			changedAt, err := time.Parse(time.RFC3339, change.LastChange)
+			if err == nil && changedAt.Before(since) {
+				done = true
+				break
+			}
+			if change.LastCheckOK == 0 {
+				removed[change.UUID] = true
+			} else {
+				changed = append(changed, change.Station)
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stations := []Station{}
+	for _, station := range c.stations[language] {
+		if !removed[station.UUID] {
+			stations = append(stations, station)
+		}
+	}
+	c.stations[language] = mergeStations(stations, changed)
+	c.refreshed[language] = started
+	fmt.Printf("[CATALOG] [%s] %d changed, %d broken, %d stations\n", language, len(changed), len(removed), len(c.stations[language]))
+	return c.save()
+}
+
+func (c *Catalog) download(rb *RadioBrowser, language string, started time.Time) error {
+	query := url.Values{}
+	query.Set("language", language)
+	query.Set("order", "clickcount")
+	query.Set("reverse", "true")
+	query.Set("hidebroken", "true")
+	query.Set("limit", fmt.Sprint(CATALOG_LIMIT))
+	stations, err := rb.Search(query)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stations[language] = stations
+	c.refreshed[language] = started
+	fmt.Printf("[CATALOG] [%s] %d stations\n", language, len(stations))
+	return c.save()
+}
+
+// Add puts stations found by a search into the catalog, replacing the
+// copies it has. It is saved with the next refresh.
+func (c *Catalog) Add(language string, stations []Station) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stations[language] = mergeStations(c.stations[language], stations)
+	c.dirty = true
+}
+
+// TopUpDue is true at most once every CATALOG_TOP_UP_INTERVAL
+func (c *Catalog) TopUpDue() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.toppedUp) < CATALOG_TOP_UP_INTERVAL {
+		return false
+	}
+	c.toppedUp = time.Now()
+	return true
+}
+
+// mergeStations replaces the stations in `stations` that are in `fresh`,
+// and adds the rest of `fresh` at the end
+func mergeStations(stations []Station, fresh []Station) []Station {
+	index := map[string]int{}
+	merged := append([]Station{}, stations...)
+	for i, station := range merged {
+		index[station.UUID] = i
+	}
+	for _, station := range fresh {
+		if i, ok := index[station.UUID]; ok && station.UUID != "" {
+			merged[i] = station
+			continue
+		}
+		index[station.UUID] = len(merged)
+		merged = append(merged, station)
+	}
+	return merged
+}
+
+// Update keeps the catalog fresh, one stale language at a time
+func (c *Catalog) Update(rb *RadioBrowser, languages []string) {
+	for {
+		for {
+			language, stale := c.Stale(languages)
+			if !stale {
+				break
+			}
+			if err := c.Refresh(rb, language); err != nil {
+				fmt.Printf("[CATALOG] [%s] %s\n", language, err)
+				break
+			}
+		}
+		c.mu.Lock()
+		if c.dirty {
+			if err := c.save(); err != nil {
+				fmt.Printf("[CATALOG] Failed to save: %s\n", err)
+			}
+		}
+		c.mu.Unlock()
+		time.Sleep(CATALOG_REFRESH_INTERVAL)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestCatalog is empty, and doesn't top up in the background, which
+// would outlive the test
+func newTestCatalog(t *testing.T) *Catalog {
+	catalog := LoadCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	catalog.toppedUp = time.Now()
+	return catalog
+}
+
+func TestCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("language") {
+		case "english":
+			w.Write([]byte(`[
+				{"stationuuid": "uuid-a", "name": "A", "tags": "jazz", "bitrate": 128},
+				{"stationuuid": "uuid-b", "name": "B", "tags": "talk", "bitrate": 128}
+			]`))
+		default:
+			w.Write([]byte(`[{"stationuuid": "uuid-c", "name": "C", "tags": "jazz", "bitrate": 32}]`))
+		}
+	}))
+	defer server.Close()
+	rb := newTestRadioBrowser(server)
+	path := filepath.Join(t.TempDir(), "catalog.json")
+
+	catalog := LoadCatalog(path)
+	if language, stale := catalog.Stale([]string{"english"}); !stale || language != "english" {
+		t.Fatalf("Expected english to be stale, got %q %v", language, stale)
+	}
+	for _, language := range []string{"english", "swedish"} {
+		if err := catalog.Refresh(rb, language); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, stale := catalog.Stale([]string{"english", "swedish"}); stale {
+		t.Error("Catalog is stale right after a refresh")
+	}
+
+	// The catalog survives a restart
+	catalog = LoadCatalog(path)
+	if catalog.Len() != 3 {
+		t.Fatalf("Expected 3 stations, got %d", catalog.Len())
+	}
+	filters := SearchFilters{Tags: []string{"jazz"}, MinBitrate: 64}
+	for i := 0; i < 10; i++ {
+		station, err := catalog.Random(Station{}, []string{"english", "swedish"}, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if station.UUID != "uuid-a" {
+			t.Fatalf("Picked %s", station.Name)
+		}
+	}
+	if _, err := catalog.Random(testStationA, []string{"english"}, filters); err == nil {
+		t.Error("Picked the current station")
+	}
+}
+
+func TestCatalogChanges(t *testing.T) {
+	since := time.Now().Add(-time.Hour)
+	queries := make(chan url.Values, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		if r.URL.Query().Get("offset") != "0" {
+			t.Errorf("Read past the last change: %s", r.URL.RawQuery)
+		}
+		newer, older := time.Now().UTC().Format(time.RFC3339), since.Add(-time.Hour).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, `[
+			{"stationuuid": "uuid-a", "name": "A renamed", "lastcheckok": 1, "lastchangetime_iso8601": %q},
+			{"stationuuid": "uuid-b", "name": "B", "lastcheckok": 0, "lastchangetime_iso8601": %q},
+			{"stationuuid": "uuid-d", "name": "D", "lastcheckok": 1, "lastchangetime_iso8601": %q},
+			{"stationuuid": "uuid-c", "name": "C changed long ago", "lastcheckok": 1, "lastchangetime_iso8601": %q}
+		]`, newer, newer, newer, older)
+	}))
+	defer server.Close()
+	defer func(page int) { CATALOG_CHANGES_PAGE = page }(CATALOG_CHANGES_PAGE)
+	CATALOG_CHANGES_PAGE = 4
+
+	catalog := LoadCatalog(filepath.Join(t.TempDir(), "catalog.json"))
+	catalog.stations["english"] = []Station{
+		{Name: "A", UUID: "uuid-a"}, {Name: "B", UUID: "uuid-b"}, {Name: "C", UUID: "uuid-c"},
+	}
+	catalog.refreshed["english"] = since
+	if err := catalog.Refresh(newTestRadioBrowser(server), "english"); err != nil {
+		t.Fatal(err)
+	}
+	if query := <-queries; query.Get("order") != "changetimestamp" || query.Get("hidebroken") != "" {
+		t.Errorf("Expected the latest changes, broken ones too: %v", query)
+	}
+	if len(queries) != 0 {
+		t.Errorf("Expected one page, got %d more", len(queries))
+	}
+	names := []string{}
+	for _, station := range catalog.stations["english"] {
+		names = append(names, station.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"A renamed", "C", "D"}) {
+		t.Errorf("Got %v", names)
+	}
+
+	// Found by a search
+	catalog.Add("english", []Station{{Name: "C again", UUID: "uuid-c"}, {Name: "E", UUID: "uuid-e"}})
+	if catalog.Len() != 4 || catalog.stations["english"][1].Name != "C again" {
+		t.Errorf("Got %v", catalog.stations["english"])
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+var CONFIG_FILE = "config.json"
+
+// Config describes how the radio is wired and what every gesture does.
+// Anything left out of `config.json` keeps its value from DEFAULT_CONFIG.
+type Config struct {
+	Buttons  map[string]int `json:"buttons"`  // GPIO by button label
+	Shift    string         `json:"shift"`    // Label of the modifier button
+	Encoder  []int          `json:"encoder"`  // GPIO of A and B, empty without an encoder
+	Dial     []int          `json:"dial"`     // GPIO of 8, 4, 2 and 1, empty without a dial
+	Gestures GestureConfig  `json:"gestures"` // Timings in milliseconds
+	Bindings []Binding      `json:"bindings"`
+
+	VolumeStep int          `json:"volume_step"` // Percent per volume step
+	Output     OutputConfig `json:"output"`
+
+	Search SearchFilters `json:"search"` // Combined with `languages.txt`
+	Places []Place       `json:"places"` // For `play_nearby`
+
+	Profiles []Profile `json:"profiles"` // Empty for one shared set of favorites
+}
+
+// OutputConfig picks the AudioOutput: `aplay`, `pipewire`, `pulse`, `wav` or `null`
+type OutputConfig struct {
+	Kind   string `json:"kind"`
+	Device string `json:"device"` // aplay, pw-cat or pacat device, default if empty
+	Path   string `json:"path"`   // wav only
+}
+
+type GestureConfig struct {
+	Hold        int `json:"hold"`
+	LongHold    int `json:"long_hold"`
+	DoublePress int `json:"double_press"`
+	Repeat      int `json:"repeat"`
+}
+
+// Binding runs `Action` when `Button` makes `Gesture`. `Button` is a label
+// from `Buttons`, or `encoder`/`dial`.
+type Binding struct {
+	Button  string `json:"button"`
+	Gesture string `json:"gesture"`
+	Shift   bool   `json:"shift,omitempty"`
+	With    string `json:"with,omitempty"` // CHORD only, the button held down first
+	Action  string `json:"action"`
+}
+
+// Pseudo button labels for bindings
+const (
+	BUTTON_ENCODER = "encoder"
+	BUTTON_DIAL    = "dial"
+)
+
+var GESTURE_NAMES = map[string]int{
+	"press":        PRESS,
+	"hold":         HOLD,
+	"turn":         TURN,
+	"dial":         DIAL,
+	"double_press": DOUBLE_PRESS,
+	"long_hold":    LONG_HOLD,
+	"chord":        CHORD,
+	"repeat":       REPEAT,
+}
+
+var DEFAULT_CONFIG = Config{
+	Buttons: map[string]int{"A": 5, "B": 6, "X": 16, "Y": 24},
+	Shift:   "A",
+	Gestures: GestureConfig{
+		Hold:        500,
+		LongHold:    2000,
+		DoublePress: 300,
+		Repeat:      250,
+	},
+	Bindings: []Binding{
+		{Button: "X", Gesture: "press", Action: "play_random"},
+		{Button: "X", Gesture: "press", Shift: true, Action: "play_similar"},
+		{Button: "X", Gesture: "hold", Action: "identify"},
+		{Button: "X", Gesture: "hold", Shift: true, Action: "switch_profile"},
+		{Button: "Y", Gesture: "press", Action: "next_favorite"},
+		{Button: "Y", Gesture: "press", Shift: true, Action: "prev_favorite"},
+		{Button: "Y", Gesture: "double_press", Action: "play_favorite"},
+		{Button: "Y", Gesture: "double_press", Shift: true, Action: "move_favorite_up"},
+		{Button: "Y", Gesture: "hold", Action: "add_favorite"},
+		{Button: "Y", Gesture: "hold", Shift: true, Action: "remove_favorite"},
+		{Button: "B", Gesture: "press", Action: "mute"},
+		// Without an encoder B is the only way to set the volume
+		{Button: "B", Gesture: "repeat", Action: "volume_up"},
+		{Button: "B", Gesture: "repeat", Shift: true, Action: "volume_down"},
+		{Button: "B", Gesture: "double_press", Action: "show_stats"},
+		{Button: BUTTON_ENCODER, Gesture: "turn", Action: "volume"},
+		{Button: BUTTON_ENCODER, Gesture: "turn", Shift: true, Action: "step_favorite"},
+		{Button: BUTTON_DIAL, Gesture: "dial", Action: "play_preset"},
+	},
+	VolumeStep: 5,
+	Output:     OutputConfig{Kind: OUTPUT_APLAY},
+	Search:     SearchFilters{HideBroken: true},
+}
+
+// CONFIG is the active config after `loadConfig()`
+var CONFIG = DEFAULT_CONFIG
+
+func loadConfig() (Config, error) {
+	config := DEFAULT_CONFIG
+	fileData, err := os.ReadFile(CONFIG_FILE)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	// Buttons are merged with the defaults, bindings replace them
+	config.Buttons = map[string]int{}
+	for label, pin := range DEFAULT_CONFIG.Buttons {
+		config.Buttons[label] = pin
+	}
+	config.Bindings = nil
+	if err := json.Unmarshal(fileData, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", CONFIG_FILE, err)
+	}
+	if config.Bindings == nil {
+		config.Bindings = DEFAULT_CONFIG.Bindings
+	}
+	return config, config.check()
+}
+
+func (config Config) check() error {
+	if _, ok := config.Buttons[config.Shift]; !ok {
+		return fmt.Errorf("shift button `%s` is not in buttons", config.Shift)
+	}
+	if len(config.Encoder) != 0 && len(config.Encoder) != 2 {
+		return fmt.Errorf("encoder needs 2 pins, got %d", len(config.Encoder))
+	}
+	if config.VolumeStep < 1 || config.VolumeStep > 100 {
+		return fmt.Errorf("volume_step must be 1-100, got %d", config.VolumeStep)
+	}
+	if config.Search.MinBitrate < 0 {
+		return fmt.Errorf("search min_bitrate must not be negative, got %d", config.Search.MinBitrate)
+	}
+	for _, place := range config.Places {
+		if place.Lat < -90 || place.Lat > 90 || place.Long < -180 || place.Long > 180 {
+			return fmt.Errorf("place `%s` is not on earth: %v, %v", place.Name, place.Lat, place.Long)
+		}
+	}
+	if err := checkProfiles(config.Profiles); err != nil {
+		return err
+	}
+	if len(config.Dial) != 0 && len(config.Dial) != 4 {
+		return fmt.Errorf("dial needs 4 pins, got %d", len(config.Dial))
+	}
+	if err := config.checkPins(); err != nil {
+		return err
+	}
+	for _, b := range config.Bindings {
+		if _, ok := config.pin(b.Button); !ok {
+			return fmt.Errorf("binding `%s`: no button `%s`", b.Action, b.Button)
+		}
+		if _, ok := GESTURE_NAMES[b.Gesture]; !ok {
+			return fmt.Errorf("binding `%s`: no gesture `%s`", b.Action, b.Gesture)
+		}
+		if b.With != "" {
+			if _, ok := config.pin(b.With); !ok {
+				return fmt.Errorf("binding `%s`: no button `%s`", b.Action, b.With)
+			}
+		}
+	}
+	return nil
+}
+
+// GPIO_PINS is how many GPIOs the Raspberry Pi header has, numbered from 0
+const GPIO_PINS = 28
+
+// checkPins makes sure every pin is a GPIO and wired to one thing only
+func (config Config) checkPins() error {
+	used := map[int]string{}
+	use := func(name string, pin int) error {
+		if pin < 0 || pin >= GPIO_PINS {
+			return fmt.Errorf("%s: no GPIO %d, expected 0-%d", name, pin, GPIO_PINS-1)
+		}
+		if other, ok := used[pin]; ok {
+			return fmt.Errorf("%s: GPIO %d is already used by %s", name, pin, other)
+		}
+		used[pin] = name
+		return nil
+	}
+	labels := []string{}
+	for label := range config.Buttons {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if err := use(fmt.Sprintf("button `%s`", label), config.Buttons[label]); err != nil {
+			return err
+		}
+	}
+	for i, pin := range config.Encoder {
+		if err := use(fmt.Sprintf("encoder %c", 'A'+i), pin); err != nil {
+			return err
+		}
+	}
+	for i, pin := range config.Dial {
+		if err := use(fmt.Sprintf("dial %d", 8>>i), pin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pin looks up the GPIO of a button label, `encoder` and `dial`
+func (config Config) pin(button string) (int, bool) {
+	switch button {
+	case BUTTON_ENCODER:
+		if len(config.Encoder) == 2 {
+			return config.Encoder[0], true
+		}
+		return ENC_A, true
+	case BUTTON_DIAL:
+		if len(config.Dial) == 4 {
+			return config.Dial[0], true
+		}
+		return DIAL_8, true
+	}
+	pin, ok := config.Buttons[button]
+	return pin, ok
+}
+
+// Apply sets the hardware globals the input backends read
+func (config Config) Apply() {
+	BUTTONS = config.Buttons
+	BTN_SHIFT = config.Buttons[config.Shift]
+	if len(config.Encoder) == 2 {
+		ENCODER_ENABLED = true
+		ENC_A, ENC_B = config.Encoder[0], config.Encoder[1]
+	}
+	if len(config.Dial) == 4 {
+		DIAL_ENABLED = true
+		DIAL_8, DIAL_4, DIAL_2, DIAL_1 = config.Dial[0], config.Dial[1], config.Dial[2], config.Dial[3]
+	}
+	VOLUME_STEP = config.VolumeStep
+	SEARCH_FILTERS = config.Search
+	PLACES = config.Places
+	GESTURE_TIMINGS = config.Gestures.Timings()
+	CONFIG = config
+}
+
+func (gestures GestureConfig) Timings() GestureTimings {
+	return GestureTimings{
+		Hold:        time.Duration(gestures.Hold) * time.Millisecond,
+		LongHold:    time.Duration(gestures.LongHold) * time.Millisecond,
+		DoublePress: time.Duration(gestures.DoublePress) * time.Millisecond,
+		Repeat:      time.Duration(gestures.Repeat) * time.Millisecond,
+	}
+}
+
+// Binding returns the action for `ev`. A shifted gesture without a
+// binding of its own falls back to the unshifted one, and a LONG_HOLD
+// without a binding to the HOLD, as a long hold never fires HOLD.
+func (config Config) Binding(ev InputEvent) (string, bool) {
+	kinds := []int{ev.Kind}
+	if ev.Kind == LONG_HOLD {
+		kinds = append(kinds, HOLD)
+	}
+	for _, shift := range []bool{ev.Shift, false} {
+		for _, kind := range kinds {
+			for _, b := range config.Bindings {
+				pin, _ := config.pin(b.Button)
+				if pin != ev.Pin || GESTURE_NAMES[b.Gesture] != kind || b.Shift != shift {
+					continue
+				}
+				if kind == CHORD {
+					with, _ := config.pin(b.With)
+					if with != ev.Value {
+						continue
+					}
+				}
+				return b.Action, true
+			}
+		}
+		if !ev.Shift {
+			break
+		}
+	}
+	return "", false
+}
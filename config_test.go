@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Pins of the X, Y and B buttons in DEFAULT_CONFIG
+var (
+	TEST_BTN_X = DEFAULT_CONFIG.Buttons["X"]
+	TEST_BTN_Y = DEFAULT_CONFIG.Buttons["Y"]
+	TEST_BTN_B = DEFAULT_CONFIG.Buttons["B"]
+)
+
+func TestLoadConfig(t *testing.T) {
+	defer func(path string) { CONFIG_FILE = path }(CONFIG_FILE)
+	CONFIG_FILE = filepath.Join(t.TempDir(), "config.json")
+
+	config, err := loadConfig()
+	if err != nil || len(config.Bindings) != len(DEFAULT_CONFIG.Bindings) {
+		t.Fatalf("Expected the defaults without a file, got %v", err)
+	}
+
+	json := `{"buttons": {"Y": 25, "C": 26}, "bindings": [{"button": "C", "gesture": "press", "action": "mute"}]}`
+	if err := os.WriteFile(CONFIG_FILE, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Buttons["A"] != 5 || config.Buttons["Y"] != 25 || config.Buttons["C"] != 26 {
+		t.Errorf("Buttons not merged with the defaults: %v", config.Buttons)
+	}
+	if len(config.Bindings) != 1 {
+		t.Errorf("Bindings not replaced: %v", config.Bindings)
+	}
+	if DEFAULT_CONFIG.Buttons["Y"] != 24 {
+		t.Errorf("The defaults were changed: %v", DEFAULT_CONFIG.Buttons)
+	}
+
+	for _, json := range []string{
+		`{"buttons": `,
+		`{"bindings": [{"button": "X", "gesture": "wiggle", "action": "mute"}]}`,
+		`{"buttons": {"C": 24}}`,
+	} {
+		if err := os.WriteFile(CONFIG_FILE, []byte(json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("Expected an error for %s", json)
+		}
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	if err := DEFAULT_CONFIG.check(); err != nil {
+		t.Fatalf("The defaults don't pass: %s", err)
+	}
+	for _, test := range []struct {
+		expected string
+		change   func(config *Config)
+	}{
+		{"shift button `Z`", func(config *Config) { config.Shift = "Z" }},
+		{"encoder needs 2 pins", func(config *Config) { config.Encoder = []int{17} }},
+		{"dial needs 4 pins", func(config *Config) { config.Dial = []int{4, 14, 15} }},
+		{"volume_step", func(config *Config) { config.VolumeStep = 0 }},
+		{"no GPIO -1", func(config *Config) { config.Buttons = map[string]int{"A": -1} }},
+		{"no GPIO 40", func(config *Config) { config.Encoder = []int{17, 40} }},
+		{"GPIO 16 is already used by button `X`", func(config *Config) { config.Encoder = []int{16, 27} }},
+		{"GPIO 5 is already used by button `A`", func(config *Config) { config.Dial = []int{4, 14, 15, 5} }},
+		{"no button `C`", func(config *Config) {
+			config.Bindings = []Binding{{Button: "C", Gesture: "press", Action: ACTION_MUTE}}
+		}},
+		{"no gesture `wiggle`", func(config *Config) {
+			config.Bindings = []Binding{{Button: "X", Gesture: "wiggle", Action: ACTION_MUTE}}
+		}},
+		{"no button `C`", func(config *Config) {
+			config.Bindings = []Binding{{Button: "X", Gesture: "chord", With: "C", Action: ACTION_MUTE}}
+		}},
+	} {
+		config := DEFAULT_CONFIG
+		config.Buttons = map[string]int{}
+		for label, pin := range DEFAULT_CONFIG.Buttons {
+			config.Buttons[label] = pin
+		}
+		test.change(&config)
+		if err := config.check(); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error with %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestConfigActions(t *testing.T) {
+	actions := Actions{}
+	for _, name := range []string{ACTION_PLAY_RANDOM, ACTION_MUTE} {
+		actions.Register(name, func(ev InputEvent) {})
+	}
+	config := DEFAULT_CONFIG
+	config.Bindings = []Binding{{Button: "X", Gesture: "press", Action: ACTION_PLAY_RANDOM}}
+	if err := actions.Check(config); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	config.Bindings = append(config.Bindings, Binding{Button: "B", Gesture: "press", Action: "self_destruct"})
+	if err := actions.Check(config); err == nil || !strings.Contains(err.Error(), "self_destruct") {
+		t.Errorf("Expected an unknown action, got %v", err)
+	}
+}
+
+func TestConfigBinding(t *testing.T) {
+	config := DEFAULT_CONFIG
+	config.Bindings = append(config.Bindings,
+		Binding{Button: "Y", Gesture: "chord", With: "X", Action: ACTION_IDENTIFY})
+	for _, test := range []struct {
+		ev       InputEvent
+		expected string
+	}{
+		{InputEvent{Pin: TEST_BTN_X, Kind: PRESS}, ACTION_PLAY_RANDOM},
+		{InputEvent{Pin: TEST_BTN_X, Kind: PRESS, Shift: true}, ACTION_PLAY_SIMILAR},
+		// No SHIFT+double press of its own, falls back to the double press
+		{InputEvent{Pin: TEST_BTN_B, Kind: DOUBLE_PRESS, Shift: true}, ACTION_SHOW_STATS},
+		{InputEvent{Pin: TEST_BTN_X, Kind: HOLD}, ACTION_IDENTIFY},
+		{InputEvent{Pin: TEST_BTN_X, Kind: HOLD, Shift: true}, ACTION_SWITCH_PROFILE},
+		{InputEvent{Pin: TEST_BTN_B, Kind: REPEAT}, ACTION_VOLUME_UP},
+		{InputEvent{Pin: TEST_BTN_B, Kind: REPEAT, Shift: true}, ACTION_VOLUME_DOWN},
+		{InputEvent{Pin: TEST_BTN_B, Kind: HOLD}, ""},
+		{InputEvent{Pin: TEST_BTN_B, Kind: LONG_HOLD}, ""},
+		// No long hold bindings, falls back to the hold
+		{InputEvent{Pin: TEST_BTN_Y, Kind: LONG_HOLD}, ACTION_ADD_FAVORITE},
+		{InputEvent{Pin: TEST_BTN_Y, Kind: LONG_HOLD, Shift: true}, ACTION_REMOVE_FAVORITE},
+		{InputEvent{Pin: TEST_BTN_X, Kind: LONG_HOLD, Shift: true}, ACTION_SWITCH_PROFILE},
+		{InputEvent{Pin: ENC_A, Kind: TURN, Value: -1}, ACTION_VOLUME},
+		{InputEvent{Pin: DIAL_8, Kind: DIAL, Value: 3}, ACTION_PLAY_PRESET},
+		{InputEvent{Pin: TEST_BTN_Y, Kind: CHORD, Value: TEST_BTN_X}, ACTION_IDENTIFY},
+		{InputEvent{Pin: TEST_BTN_Y, Kind: CHORD, Value: TEST_BTN_B}, ""},
+	} {
+		action, ok := config.Binding(test.ev)
+		if action != test.expected || ok != (test.expected != "") {
+			t.Errorf("%s: expected %q, got %q", test.ev, test.expected, action)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Tag searches run for the SIMILAR_SEARCH_TAGS strongest tags of the seed
+const SIMILAR_SEARCH_TAGS = 3
+
+// TagWeights is how much a tag counts towards similarity
+type TagWeights map[string]float64
+
+// stationTags splits radio-browser's comma separated tags
+func stationTags(tags string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(strings.ToLower(tags), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// SeedTags counts the tags of `stations`, a tag on every station weighs 1
+func SeedTags(stations ...Station) TagWeights {
+	weights := TagWeights{}
+	for _, station := range stations {
+		for _, tag := range stationTags(station.Tags) {
+			weights[tag] += 1 / float64(len(stations))
+		}
+	}
+	return weights
+}
+
+// Strongest are the `n` heaviest tags
+func (weights TagWeights) Strongest(n int) []string {
+	tags := []string{}
+	for tag := range weights {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if weights[tags[i]] != weights[tags[j]] {
+			return weights[tags[i]] > weights[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if len(tags) > n {
+		tags = tags[:n]
+	}
+	return tags
+}
+
+// Similarity is between 0 and 1. Tags the seed doesn't have count against
+// the station, so `jazz` beats `jazz, pop, news, talk` for a jazz seed.
+func (weights TagWeights) Similarity(station Station) float64 {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	matched, unmatched := 0.0, 0
+	for _, tag := range stationTags(station.Tags) {
+		if weight, ok := weights[tag]; ok {
+			matched += weight
+		} else {
+			unmatched++
+		}
+	}
+	if matched == 0 {
+		return 0
+	}
+	return matched / (total + float64(unmatched))
+}
+
+// get_similar_station picks a station with tags like the current one's,
+// or like the favorites' if it has none. Without a match it is as good
+// as get_random_station.
+func get_similar_station(currentStation Station, favorites []Station) (Station, error) {
+	seed := SeedTags(currentStation)
+	if len(seed) == 0 {
+		seed = SeedTags(favorites...)
+	}
+	return get_station_like(seed, currentStation)
+}
+
+// get_station_like_favorites picks a station with tags like the favorites
+func get_station_like_favorites(currentStation Station, favorites []Station) (Station, error) {
+	return get_station_like(SeedTags(favorites...), currentStation)
+}
+
+func get_station_like(seed TagWeights, currentStation Station) (Station, error) {
+	if len(seed) == 0 {
+		fmt.Println("[SIMILAR] Nothing to go on, picking at random")
+		return get_random_station(currentStation)
+	}
+	tags := seed.Strongest(SIMILAR_SEARCH_TAGS)
+	fmt.Printf("[SIMILAR] Looking for %s\n", strings.Join(tags, ", "))
+
+	// Genres cross languages, so the tag searches don't ask for one
+	stationsResult := make(chan []Station)
+	for _, tag := range tags {
+		go func(tag string) {
+			query := station_search_query(10, PickOne(STATION_SORT_FIELDS), "", SEARCH_FILTERS)
+			query.Set("tag", tag)
+			stations, err := RADIO_BROWSER.Search(query)
+			if err != nil {
+				log.Printf("[%s] Failed: %s", tag, err)
+			}
+			stationsResult <- stations
+		}(tag)
+	}
+	candidates := []Station{}
+	for range tags {
+		for _, station := range <-stationsResult {
+			if station.UUID != currentStation.UUID && SEARCH_FILTERS.Allows(station) {
+				candidates = append(candidates, station)
+			}
+		}
+	}
+	// A station can turn up in several tag searches and the catalog, what
+	// the API just said about it is the fresher
+	if CATALOG != nil {
+		candidates = append(candidates, CATALOG.Candidates(currentStation, Languages(), SEARCH_FILTERS)...)
+	}
+	candidates = dedupeStations(candidates)
+
+	if station, ok := RELIABILITY.PickBy(candidates, seed.Similarity); ok {
+		return station, nil
+	}
+	fmt.Println("[SIMILAR] No match, picking at random")
+	return get_random_station(currentStation)
+}
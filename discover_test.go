@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTagSimilarity(t *testing.T) {
+	seed := SeedTags(Station{Tags: "Jazz, smooth jazz,jazz"})
+	if len(seed) != 2 || seed["jazz"] != 1 {
+		t.Fatalf("Unexpected seed: %v", seed)
+	}
+	jazz := seed.Similarity(Station{Tags: "jazz"})
+	mixed := seed.Similarity(Station{Tags: "jazz,pop,news,talk"})
+	both := seed.Similarity(Station{Tags: "smooth jazz,jazz"})
+	if !(both > jazz && jazz > mixed && mixed > 0) {
+		t.Errorf("Expected both > jazz > mixed > 0, got %v %v %v", both, jazz, mixed)
+	}
+	if seed.Similarity(Station{Tags: "talk"}) != 0 {
+		t.Error("Unrelated station is similar")
+	}
+
+	favorites := SeedTags(Station{Tags: "rock"}, Station{Tags: "rock,blues"}, Station{Tags: "pop"})
+	if strongest := favorites.Strongest(2); strongest[0] != "rock" || len(strongest) != 2 {
+		t.Errorf("Unexpected strongest tags: %v", strongest)
+	}
+}
+
+func TestSimilarStation(t *testing.T) {
+	// radio-browser is down, the catalog has to do
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	defer func(rb *RadioBrowser, catalog *Catalog, languages []string) {
+		RADIO_BROWSER, CATALOG, LANGUAGES = rb, catalog, languages
+	}(RADIO_BROWSER, CATALOG, LANGUAGES)
+	RADIO_BROWSER = newTestRadioBrowser(server)
+	LANGUAGES = []string{"english"}
+	CATALOG = newTestCatalog(t)
+	CATALOG.stations["english"] = []Station{
+		{Name: "Jazz", UUID: "uuid-jazz", Tags: "jazz"},
+		{Name: "Talk", UUID: "uuid-talk", Tags: "talk,news"},
+	}
+
+	for i := 0; i < 10; i++ {
+		station, err := get_similar_station(Station{UUID: "uuid-current", Tags: "jazz,blues"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if station.UUID != "uuid-jazz" {
+			t.Fatalf("Picked %s", station.Name)
+		}
+	}
+
+	// Nothing alike falls back to random
+	station, err := get_similar_station(Station{Tags: "polka"}, nil)
+	if err != nil || station.UUID == "" {
+		t.Errorf("Expected a random station, got %v %v", station, err)
+	}
+
+	// No tags of its own, so the favorites are the seed
+	favorites := []Station{{Tags: "news"}}
+	station, err = get_similar_station(Station{}, favorites)
+	if err != nil || station.UUID != "uuid-talk" {
+		t.Errorf("Expected Talk, got %v %v", station.Name, err)
+	}
+}
+
+func TestSimilarStationDeduped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "Jazz FM", "stationuuid": "uuid-jazz", "tags": "jazz"}]`))
+	}))
+	defer server.Close()
+	defer func(rb *RadioBrowser, catalog *Catalog, languages []string) {
+		RADIO_BROWSER, CATALOG, LANGUAGES = rb, catalog, languages
+	}(RADIO_BROWSER, CATALOG, LANGUAGES)
+	RADIO_BROWSER = newTestRadioBrowser(server)
+	LANGUAGES = []string{"english"}
+	CATALOG = newTestCatalog(t)
+	CATALOG.stations["english"] = []Station{{Name: "Jazz", UUID: "uuid-jazz", Tags: "jazz"}}
+
+	// Stations without a UUID can't be told apart
+	if stations := dedupeStations([]Station{testStationA, {}, {}, testStationA}); len(stations) != 3 {
+		t.Errorf("Expected 3 stations, got %v", stations)
+	}
+
+	// Found by both tag searches and the catalog, the API's is the one kept
+	for i := 0; i < 5; i++ {
+		station, err := get_similar_station(Station{Tags: "jazz,blues"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if station.Name != "Jazz FM" {
+			t.Fatalf("Picked %s", station.Name)
+		}
+	}
+}
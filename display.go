@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"image"
 	"image/color"
+	"image/draw"
+	"image/png"
 	"io"
 	"log"
 	"math/rand"
@@ -14,7 +17,6 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/rubiojr/go-pirateaudio/display"
 	"github.com/skip2/go-qrcode"
 )
 
@@ -33,6 +35,14 @@ const (
 	OKAY
 	HUH
 	TRASH
+	VOLUME
+	INFO
+)
+
+// How long the volume bar stays up after the last change, and an info card
+var (
+	VOLUME_OVERLAY_DURATION = 2 * time.Second
+	INFO_OVERLAY_DURATION   = 5 * time.Second
 )
 
 type StatusConfig struct {
@@ -47,6 +57,16 @@ type QR struct {
 	RestoreState int
 }
 
+type VolumeLevel struct {
+	Level int
+	Muted bool
+}
+
+// Info is a few lines of text, the first one is the title
+type Info struct {
+	Lines []string
+}
+
 var DISPLAY_CONFIGS = map[int]StatusConfig{
 
 	// `PERMANENT` means the animation will play forever until a new animation overrides it
@@ -66,32 +86,33 @@ var DISPLAY_CONFIGS = map[int]StatusConfig{
 }
 
 type Display struct {
-	dsp           *display.Display
+	dsp           Renderer
 	imageBuffer   []*InfiniteReader
 	cancel        context.CancelFunc
 	last_set      map[string]int
 	last_frame    map[string]int
 	renderChan    chan int
 	currentStatus int
+	lastStatus    int // what to go back to once the volume bar or info is done
+	overlayTimer  *time.Timer
 	ShowStatus    chan int
 	ShowQR        chan QR
+	ShowVolume    chan VolumeLevel
+	ShowInfo      chan Info
+	blank         chan chan bool
 }
 
-func NewDisplay() (*Display, error) {
+func NewDisplay(renderer Renderer) (*Display, error) {
 	d := &Display{}
-	err := d.Init()
+	err := d.Init(renderer)
 	if err != nil {
 		return nil, err
 	}
 	return d, nil
 }
 
-func (d *Display) Init() error {
-	dsp, err := display.Init()
-	if err != nil {
-		return err
-	}
-	d.dsp = dsp
+func (d *Display) Init(renderer Renderer) error {
+	d.dsp = renderer
 	d.dsp.FillScreen(color.RGBA{R: 0, G: 0, B: 0, A: 0})
 	last_set, last_frame, err := processDirectory(STATUS_IMAGES_PATH)
 	if err != nil {
@@ -101,22 +122,80 @@ func (d *Display) Init() error {
 	d.last_frame = last_frame
 	d.ShowStatus = make(chan int)
 	d.ShowQR = make(chan QR)
+	d.ShowVolume = make(chan VolumeLevel)
+	d.ShowInfo = make(chan Info)
+	d.blank = make(chan chan bool)
+	d.overlayTimer = time.NewTimer(VOLUME_OVERLAY_DURATION)
+	d.stopOverlay()
 	go func() {
 		for {
 			select {
 			case status := <-d.ShowStatus:
+				d.stopOverlay()
 				if status == d.currentStatus {
 					continue
 				}
 				d.showStatus(status)
 			case qr := <-d.ShowQR:
+				d.stopOverlay()
 				d.showQR(qr.String, qr.Temporary, qr.RestoreState)
+			case volume := <-d.ShowVolume:
+				d.showVolume(volume)
+				d.stopOverlay()
+				d.overlayTimer.Reset(VOLUME_OVERLAY_DURATION)
+			case info := <-d.ShowInfo:
+				d.showInfo(info)
+				d.stopOverlay()
+				d.overlayTimer.Reset(INFO_OVERLAY_DURATION)
+			case <-d.overlayTimer.C:
+				d.showStatus(d.lastStatus)
+			case done := <-d.blank:
+				if d.cancel != nil {
+					d.cancel()
+				}
+				d.cancel = nil
+				d.dsp.FillScreen(color.RGBA{R: 0, G: 0, B: 0, A: 0})
+				done <- true
+				d.drain()
+				return
 			}
 		}
 	}()
 	return nil
 }
 
+// stopOverlay stops the overlay timer and empties its channel, a timer that
+// fired unnoticed would dismiss the next overlay right away
+func (d *Display) stopOverlay() {
+	if !d.overlayTimer.Stop() {
+		select {
+		case <-d.overlayTimer.C:
+		default:
+		}
+	}
+}
+
+// Blank stops any animation and clears the screen for good
+func (d *Display) Blank() {
+	done := make(chan bool)
+	d.blank <- done
+	<-done
+}
+
+// drain swallows whatever is still sent after Blank so no sender is left hanging
+func (d *Display) drain() {
+	for {
+		select {
+		case <-d.ShowStatus:
+		case <-d.ShowQR:
+		case <-d.ShowVolume:
+		case <-d.ShowInfo:
+		case done := <-d.blank:
+			done <- true
+		}
+	}
+}
+
 func (d *Display) showQR(str string, temporary int, restoreState int) error {
 	png, err := qrcode.Encode(str, qrcode.Medium, 240)
 	if err != nil {
@@ -155,9 +234,106 @@ func (d *Display) showStatus(status int) {
 		go d.restorePreviousStatusAfter(5, config.RestoreState)
 	}
 	d.currentStatus = status
+	d.lastStatus = status
 	go d.playAnimation(config.RefreshRate)
 }
 
+// showVolume draws a bar over the whole screen, the animation underneath
+// comes back when the volume timer runs out
+func (d *Display) showVolume(volume VolumeLevel) {
+	png, err := drawVolumeBar(volume)
+	if err != nil {
+		log.Printf("[DISPLAY: volume] Error: %v", err)
+		return
+	}
+	imageInfiniteReader, _ := NewInfiniteReader(bytes.NewReader(png))
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.cancel = nil
+	d.currentStatus = VOLUME
+	d.imageBuffer = []*InfiniteReader{imageInfiniteReader}
+	go d.displayStatic()
+}
+
+// showInfo draws text over the whole screen, like the volume bar
+func (d *Display) showInfo(info Info) {
+	png, err := drawInfo(info)
+	if err != nil {
+		log.Printf("[DISPLAY: info] Error: %v", err)
+		return
+	}
+	imageInfiniteReader, _ := NewInfiniteReader(bytes.NewReader(png))
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.cancel = nil
+	d.currentStatus = INFO
+	d.imageBuffer = []*InfiniteReader{imageInfiniteReader}
+	go d.displayStatic()
+}
+
+func drawInfo(info Info) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, DISPLAY_WIDTH, DISPLAY_HEIGHT))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{A: 255}}, image.Point{}, draw.Src)
+	margin, gap := 10, 8
+	scales := make([]int, len(info.Lines))
+	height := 0
+	for i := range info.Lines {
+		// The title is bigger
+		scales[i] = 2
+		if i == 0 {
+			scales[i] = 3
+		}
+		height += GLYPH_HEIGHT*scales[i] + gap
+	}
+	y := (DISPLAY_HEIGHT - height + gap) / 2
+	for i, line := range info.Lines {
+		line = FitText(line, DISPLAY_WIDTH-2*margin, scales[i])
+		x := (DISPLAY_WIDTH - TextWidth(line, scales[i])) / 2
+		c := color.RGBA{R: 180, G: 180, B: 180, A: 255}
+		if i == 0 {
+			c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+		}
+		DrawText(img, image.Point{x, y}, scales[i], c, line)
+		y += GLYPH_HEIGHT*scales[i] + gap
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawVolumeBar(volume VolumeLevel) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, DISPLAY_WIDTH, DISPLAY_HEIGHT))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{A: 255}}, image.Point{}, draw.Src)
+	fill := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	if volume.Muted {
+		fill = color.RGBA{R: 200, G: 40, B: 40, A: 255}
+	}
+	// 20 segments, one for every 5%
+	segments := 20
+	lit := (volume.Level*segments + 50) / 100
+	left, width, gap := 20, 200, 2
+	segmentWidth := width / segments
+	for i := 0; i < segments; i++ {
+		// Segments get taller to the right, like a volume wedge
+		height := 20 + 80*i/(segments-1)
+		rect := image.Rect(left+i*segmentWidth, 170-height, left+(i+1)*segmentWidth-gap, 170)
+		c := color.RGBA{R: 60, G: 60, B: 60, A: 255}
+		if i < lit {
+			c = fill
+		}
+		draw.Draw(img, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func (d *Display) checkImages(prefix string) error {
 	_, ok := d.last_set[prefix]
 	if !ok {
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Favorites go to and come from other players as M3U, PLS or OPML
+const PLAYLIST_OPML = "opml"
+
+var EXCHANGE_EXTENSIONS = map[string]string{
+	".m3u":  PLAYLIST_M3U,
+	".m3u8": PLAYLIST_M3U,
+	".pls":  PLAYLIST_PLS,
+	".opml": PLAYLIST_OPML,
+}
+
+// Stations that radio-browser doesn't know get a UUID made from their URL
+const LOCAL_UUID_PREFIX = "local-"
+
+// radio-browser's own playlists point at /m3u/url/<uuid> and friends
+var radioBrowserURL = regexp.MustCompile(`/(?:json|m3u|pls)/url/([0-9a-fA-F-]{36})$`)
+
+func exchangeFormat(path string) (string, error) {
+	format, ok := EXCHANGE_EXTENSIONS[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "", fmt.Errorf("Unknown format `%s`, use .m3u, .m3u8, .pls or .opml", filepath.Ext(path))
+	}
+	return format, nil
+}
+
+// ExportFavorites writes `stations` to `path`, in the format its extension says
+func ExportFavorites(path string, stations []Station) error {
+	format, err := exchangeFormat(path)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteStations(buf, format, stations); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, buf.Bytes())
+}
+
+// ImportFavorites reads the stations in `path` and adds the new ones to
+// `favorites`. Returns the favorites and how many were added.
+func ImportFavorites(path string, favorites []Station, rb *RadioBrowser) ([]Station, int, error) {
+	format, err := exchangeFormat(path)
+	if err != nil {
+		return favorites, 0, err
+	}
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return favorites, 0, err
+	}
+	entries, err := ReadStations(format, fileData)
+	if err != nil {
+		return favorites, 0, err
+	}
+	merged, added := MergeFavorites(favorites, entries, rb)
+	return merged, added, nil
+}
+
+// MergeFavorites matches each entry to a radio-browser station, by UUID
+// if it has one, by URL if it doesn't, and appends the ones that are not
+// favorites yet. Entries radio-browser doesn't know are kept as they are.
+func MergeFavorites(favorites []Station, entries []Station, rb *RadioBrowser) ([]Station, int) {
+	merged := append([]Station{}, favorites...)
+	added := 0
+	for _, entry := range entries {
+		if favoriteMatching(merged, entry) >= 0 {
+			fmt.Printf("[IMPORT] Already a favorite: %s\n", entry.Name)
+			continue
+		}
+		station := matchStation(entry, rb)
+		if favoriteMatching(merged, station) >= 0 {
+			fmt.Printf("[IMPORT] Already a favorite: %s\n", station.Name)
+			continue
+		}
+		fmt.Printf("[IMPORT] Added: %s\n", station.Name)
+		merged = append(merged, station)
+		added++
+	}
+	return merged, added
+}
+
+// favoriteMatching is the index of the favorite with the UUID or a URL of
+// `station`, or -1
+func favoriteMatching(favorites []Station, station Station) int {
+	for i, favorite := range favorites {
+		if station.UUID != "" && favorite.UUID == station.UUID {
+			return i
+		}
+		for _, favoriteURL := range favorite.StreamURLs() {
+			for _, stationURL := range station.StreamURLs() {
+				if sameURL(favoriteURL, stationURL) {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func sameURL(a, b string) bool {
+	return a != "" && strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+func matchStation(entry Station, rb *RadioBrowser) Station {
+	if entry.UUID != "" {
+		station, err := rb.StationByUUID(entry.UUID)
+		if err == nil {
+			return withBackupURL(station, entry.URL)
+		}
+		fmt.Printf("[IMPORT] [%s] %s\n", entry.Name, err)
+	} else {
+		stations, err := rb.StationsByURL(entry.URL)
+		if err == nil && len(stations) > 0 {
+			return withBackupURL(stations[0], entry.URL)
+		}
+		if err != nil {
+			fmt.Printf("[IMPORT] [%s] %s\n", entry.Name, err)
+		}
+	}
+	if entry.UUID == "" {
+		sum := sha1.Sum([]byte(entry.URL))
+		entry.UUID = LOCAL_UUID_PREFIX + hex.EncodeToString(sum[:8])
+	}
+	if entry.Name == "" {
+		entry.Name = entry.URL
+	}
+	return entry
+}
+
+// withBackupURL keeps the imported URL for when radio-browser's fails
+func withBackupURL(station Station, entryURL string) Station {
+	for _, stationURL := range station.StreamURLs() {
+		if sameURL(stationURL, entryURL) {
+			return station
+		}
+	}
+	station.URLs = append(station.URLs, entryURL)
+	return station
+}
+
+// WriteStations writes `stations` as a PLAYLIST_M3U, PLAYLIST_PLS or
+// PLAYLIST_OPML list
+func WriteStations(w io.Writer, format string, stations []Station) error {
+	switch format {
+	case PLAYLIST_M3U:
+		return writeM3U(w, stations)
+	case PLAYLIST_PLS:
+		return writePLS(w, stations)
+	case PLAYLIST_OPML:
+		return writeOPML(w, stations)
+	}
+	return fmt.Errorf("Unknown format `%s`", format)
+}
+
+// ReadStations reads a PLAYLIST_M3U, PLAYLIST_PLS or PLAYLIST_OPML list.
+// Only M3U and OPML carry UUIDs, and only the ones we or radio-browser
+// wrote.
+func ReadStations(format string, fileData []byte) ([]Station, error) {
+	var stations []Station
+	var err error
+	switch format {
+	case PLAYLIST_M3U:
+		stations = readM3U(fileData)
+	case PLAYLIST_PLS:
+		stations = readPLS(fileData)
+	case PLAYLIST_OPML:
+		stations, err = readOPML(fileData)
+	default:
+		err = fmt.Errorf("Unknown format `%s`", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range stations {
+		if stations[i].UUID == "" {
+			if match := radioBrowserURL.FindStringSubmatch(stations[i].URL); match != nil {
+				stations[i].UUID = strings.ToLower(match[1])
+			}
+		}
+	}
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("No stations in %s list", format)
+	}
+	return stations, nil
+}
+
+// #EXTM3U
+// #EXTINF:-1,Station
+// #RADIOBROWSERUUID:...
+// http://...
+func writeM3U(w io.Writer, stations []Station) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#EXTM3U")
+	for _, station := range stations {
+		fmt.Fprintf(b, "#EXTINF:-1,%s\n", oneLine(station.Name))
+		if !strings.HasPrefix(station.UUID, LOCAL_UUID_PREFIX) {
+			fmt.Fprintf(b, "#RADIOBROWSERUUID:%s\n", station.UUID)
+		}
+		fmt.Fprintln(b, station.URL)
+	}
+	return b.Flush()
+}
+
+func readM3U(fileData []byte) []Station {
+	stations := []Station{}
+	next := Station{}
+	scanner := bufio.NewScanner(bytes.NewReader(fileData))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			next.Name = extinfTitle(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#RADIOBROWSERUUID:"):
+			next.UUID = strings.TrimSpace(strings.TrimPrefix(line, "#RADIOBROWSERUUID:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			next.URL = line
+			stations = append(stations, next)
+			next = Station{}
+		}
+	}
+	return stations
+}
+
+// extinfTitle is what follows the first comma outside quotes, attributes
+// like tvg-name="..." may hold commas
+func extinfTitle(info string) string {
+	quoted := false
+	for i, r := range info {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			return strings.TrimSpace(info[i+1:])
+		}
+	}
+	return ""
+}
+
+// [playlist]
+// File1=http://...
+// Title1=Station
+func writePLS(w io.Writer, stations []Station) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "[playlist]")
+	for i, station := range stations {
+		fmt.Fprintf(b, "File%d=%s\n", i+1, station.URL)
+		fmt.Fprintf(b, "Title%d=%s\n", i+1, oneLine(station.Name))
+		fmt.Fprintf(b, "Length%d=-1\n", i+1)
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\n", len(stations))
+	fmt.Fprintln(b, "Version=2")
+	return b.Flush()
+}
+
+func readPLS(fileData []byte) []Station {
+	entries := map[int]*Station{}
+	entry := func(n int) *Station {
+		if entries[n] == nil {
+			entries[n] = &Station{}
+		}
+		return entries[n]
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(fileData))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, "file")); err == nil && strings.HasPrefix(key, "file") {
+			entry(n).URL = value
+		} else if n, err := strconv.Atoi(strings.TrimPrefix(key, "title")); err == nil && strings.HasPrefix(key, "title") {
+			entry(n).Name = value
+		}
+	}
+	numbers := []int{}
+	for n := range entries {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	stations := []Station{}
+	for _, n := range numbers {
+		if entries[n].URL != "" {
+			stations = append(stations, *entries[n])
+		}
+	}
+	return stations
+}
+
+// <opml version="2.0"><body><outline type="audio" text="Station" URL="http://..." /></body></opml>
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Type     string        `xml:"type,attr,omitempty"`
+	URL      string        `xml:"URL,attr,omitempty"`
+	UUID     string        `xml:"stationuuid,attr,omitempty"`
+	Attrs    []xml.Attr    `xml:",any,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+type opmlDocument struct {
+	XMLName  xml.Name      `xml:"opml"`
+	Version  string        `xml:"version,attr"`
+	Title    string        `xml:"head>title"`
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+func writeOPML(w io.Writer, stations []Station) error {
+	doc := opmlDocument{Version: "2.0", Title: "whatradio favorites"}
+	for _, station := range stations {
+		outline := opmlOutline{Text: station.Name, Type: "audio", URL: station.URL}
+		if !strings.HasPrefix(station.UUID, LOCAL_UUID_PREFIX) {
+			outline.UUID = station.UUID
+		}
+		doc.Outlines = append(doc.Outlines, outline)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func readOPML(fileData []byte) ([]Station, error) {
+	doc := opmlDocument{}
+	if err := xml.Unmarshal(fileData, &doc); err != nil {
+		return nil, err
+	}
+	stations := []Station{}
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, outline := range outlines {
+			streamURL := outline.URL
+			// Not every player agrees on how to spell it
+			for _, attr := range outline.Attrs {
+				if streamURL == "" && strings.EqualFold(attr.Name.Local, "url") {
+					streamURL = attr.Value
+				}
+			}
+			if u, err := url.Parse(streamURL); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				stations = append(stations, Station{Name: outline.Text, UUID: outline.UUID, URL: streamURL})
+			}
+			walk(outline.Outlines)
+		}
+	}
+	walk(doc.Outlines)
+	return stations, nil
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
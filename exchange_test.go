@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExchangeRoundTrip(t *testing.T) {
+	local := Station{Name: "Pirate, Radio", UUID: LOCAL_UUID_PREFIX + "0123", URL: "http://pirate"}
+	stations := []Station{testStationA, testStationB, local}
+	for _, format := range []string{PLAYLIST_M3U, PLAYLIST_PLS, PLAYLIST_OPML} {
+		buf := &bytes.Buffer{}
+		if err := WriteStations(buf, format, stations); err != nil {
+			t.Fatalf("[%s] %s", format, err)
+		}
+		read, err := ReadStations(format, buf.Bytes())
+		if err != nil {
+			t.Fatalf("[%s] %s", format, err)
+		}
+		if len(read) != len(stations) {
+			t.Fatalf("[%s] Read %d stations: %v", format, len(read), read)
+		}
+		for i, station := range read {
+			if station.Name != stations[i].Name || station.URL != stations[i].URL {
+				t.Errorf("[%s] Expected %v, got %v", format, stations[i], station)
+			}
+		}
+		// PLS has nowhere to put them, local UUIDs are never written
+		if format != PLAYLIST_PLS && (read[0].UUID != testStationA.UUID || read[2].UUID != "") {
+			t.Errorf("[%s] UUIDs not kept: %v", format, read)
+		}
+	}
+}
+
+func TestReadStations(t *testing.T) {
+	m3u := "\ufeff#EXTM3U\n" +
+		"#EXTINF:-1 tvg-name=\"Jazz, Smooth\" tvg-logo=\"http://logo\",Smooth Jazz\n" +
+		"http://jazz/stream\n" +
+		"# a comment\n" +
+		"http://nameless\n" +
+		"#EXTINF:-1,Browser\n" +
+		"http://de1.api.radio-browser.info/m3u/url/960E3A13-0601-11E8-AE97-52543BE04C81\n"
+	stations, err := ReadStations(PLAYLIST_M3U, []byte(m3u))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 3 || stations[0].Name != "Smooth Jazz" || stations[1].Name != "" {
+		t.Fatalf("Unexpected stations: %v", stations)
+	}
+	if stations[2].UUID != "960e3a13-0601-11e8-ae97-52543be04c81" {
+		t.Errorf("UUID not taken from the URL: %v", stations[2])
+	}
+
+	pls := "[playlist]\nTitle2=Two\nFile2=http://two\nfile1=http://one\nNumberOfEntries=2\n"
+	stations, err = ReadStations(PLAYLIST_PLS, []byte(pls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 2 || stations[0].URL != "http://one" || stations[1].Name != "Two" {
+		t.Errorf("Unexpected stations: %v", stations)
+	}
+
+	opml := `<opml version="1.0"><body><outline text="Folder">
+		<outline type="audio" text="Nested" url="http://nested" />
+		<outline type="link" text="Not a stream" URL="Browse.ashx?id=1" />
+	</outline></body></opml>`
+	stations, err = ReadStations(PLAYLIST_OPML, []byte(opml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 1 || stations[0].Name != "Nested" || stations[0].URL != "http://nested" {
+		t.Errorf("Unexpected stations: %v", stations)
+	}
+
+	if _, err := ReadStations(PLAYLIST_PLS, []byte("[playlist]\n")); err == nil {
+		t.Errorf("Expected an error for an empty list")
+	}
+}
+
+func TestMergeFavorites(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/json/stations/byuuid/uuid-d":
+			w.Write([]byte(`[{"name": "D", "stationuuid": "uuid-d", "url_resolved": "http://d"}]`))
+		case r.URL.Path == "/json/stations/byurl" && r.URL.Query().Get("url") == "http://e/listen.pls":
+			w.Write([]byte(`[{"name": "E", "stationuuid": "uuid-e", "url_resolved": "http://e"}]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+	rb := newTestRadioBrowser(server)
+
+	entries := []Station{
+		{Name: "A again", URL: "http://a/"},                    // by URL
+		{Name: "B again", UUID: "uuid-b", URL: "http://other"}, // by UUID
+		{Name: "D", UUID: "uuid-d", URL: "http://d"},
+		{Name: "E", URL: "http://e/listen.pls"},
+		{Name: "Unknown", URL: "http://unknown"},
+		{Name: "Unknown twice", URL: "http://unknown"},
+	}
+	merged, added := MergeFavorites([]Station{testStationA, testStationB}, entries, rb)
+	if added != 3 || len(merged) != 5 {
+		t.Fatalf("Expected 3 added, got %d: %v", added, merged)
+	}
+	names := []string{}
+	for _, station := range merged {
+		names = append(names, station.Name)
+	}
+	if strings.Join(names, "") != "ABDEUnknown" {
+		t.Errorf("Unexpected favorites: %v", names)
+	}
+	if e := merged[3]; e.UUID != "uuid-e" || len(e.URLs) != 1 || e.URLs[0] != "http://e/listen.pls" {
+		t.Errorf("Expected E with the imported URL as a backup, got %v", e)
+	}
+	if unknown := merged[4]; !strings.HasPrefix(unknown.UUID, LOCAL_UUID_PREFIX) {
+		t.Errorf("Expected a local UUID, got %v", unknown)
+	}
+}
+
+func TestExportFavorites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "favorites.txt")
+	if err := ExportFavorites(path, []Station{testStationA}); err == nil {
+		t.Errorf("Expected an error for an unknown extension")
+	}
+	path = filepath.Join(t.TempDir(), "favorites.M3U8")
+	if err := ExportFavorites(path, []Station{testStationA}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing on radio-browser, A is kept as it was
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	favorites, added, err := ImportFavorites(path, []Station{}, newTestRadioBrowser(server))
+	if err != nil || added != 1 || favorites[0].UUID != testStationA.UUID {
+		t.Errorf("Expected A back, got %v %v", favorites, err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// FAVORITES_VERSION is the schema `favstations.json` is written with.
+// Version 0 is the bare list of stations from before the schema.
+const FAVORITES_VERSION = 1
+
+var DEFAULT_FAVORITES = []Station{
+	BBC_ONE,
+	{
+		Name: "106,7 Rockklassiker",
+		UUID: "9642ad8b-0601-11e8-ae97-52543be04c81",
+		URL:  "http://edge-bauerse-02-thn.sharp-stream.com/rockklassiker_instream_se_mp3?ua=WEB&",
+		Tags: "classic rock",
+	},
+}
+
+type favoritesFile struct {
+	Version  int       `json:"version"`
+	Stations []Station `json:"stations"`
+}
+
+// FAVORITES_MIGRATIONS[v] turns a version `v` file into version `v+1`
+var FAVORITES_MIGRATIONS = []func(fileData []byte) ([]byte, error){
+	// 0: a bare list of stations
+	func(fileData []byte) ([]byte, error) {
+		stations := []Station{}
+		if err := json.Unmarshal(fileData, &stations); err != nil {
+			return nil, err
+		}
+		return json.Marshal(favoritesFile{Version: 1, Stations: stations})
+	},
+}
+
+// ErrNewerFavorites means the file was written by a newer version, which
+// we must not overwrite with what little we understand of it
+var ErrNewerFavorites = errors.New("Favorites are from a newer version")
+
+// FavoritesStore keeps the favorites in `Path`, written atomically, with
+// the last good file in `Path.bak`. If both are unreadable the defaults
+// are used, so a bad SD card write never leaves the radio with nothing.
+// A file from a newer version is never saved over.
+type FavoritesStore struct {
+	Path string
+}
+
+func NewFavoritesStore(path string) *FavoritesStore {
+	return &FavoritesStore{Path: path}
+}
+
+func (store *FavoritesStore) backupPath() string {
+	return store.Path + ".bak"
+}
+
+// Load never fails, but it does complain
+func (store *FavoritesStore) Load() []Station {
+	stations, migrated, err := readFavorites(store.Path)
+	if err == nil {
+		if migrated {
+			fmt.Printf("[FAVORITES] Upgrading %s to version %d\n", store.Path, FAVORITES_VERSION)
+			if err := store.Save(stations); err != nil {
+				fmt.Printf("[FAVORITES] Failed to save: %s\n", err)
+			}
+		}
+		return stations
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return DEFAULT_FAVORITES
+	}
+	fmt.Printf("[FAVORITES] %s: %s\n", store.Path, err)
+	stations, _, backupErr := readFavorites(store.backupPath())
+	if backupErr == nil {
+		fmt.Printf("[FAVORITES] Recovered %d stations from %s\n", len(stations), store.backupPath())
+		if !errors.Is(err, ErrNewerFavorites) {
+			// Put the good copy back, or the next save would back up the broken one
+			if err := store.write(stations); err != nil {
+				fmt.Printf("[FAVORITES] Failed to restore: %s\n", err)
+			}
+		}
+		return stations
+	}
+	fmt.Printf("[FAVORITES] %s: %s, using the defaults\n", store.backupPath(), backupErr)
+	return DEFAULT_FAVORITES
+}
+
+// Save backs up the current file if it is good, then replaces it. The
+// version is checked on disk every time, as every caller has its own store.
+func (store *FavoritesStore) Save(stations []Station) error {
+	if fileData, err := os.ReadFile(store.Path); err == nil {
+		_, _, err := parseFavorites(fileData)
+		if errors.Is(err, ErrNewerFavorites) {
+			return fmt.Errorf("Not saving over %s: %w", store.Path, err)
+		}
+		if err == nil {
+			if err := WriteFileAtomic(store.backupPath(), fileData); err != nil {
+				return err
+			}
+		}
+	}
+	return store.write(stations)
+}
+
+func (store *FavoritesStore) write(stations []Station) error {
+	fileData, err := json.MarshalIndent(favoritesFile{Version: FAVORITES_VERSION, Stations: stations}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(store.Path, fileData)
+}
+
+func readFavorites(path string) ([]Station, bool, error) {
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	return parseFavorites(fileData)
+}
+
+// parseFavorites migrates `fileData` up to FAVORITES_VERSION, and says if
+// it had to
+func parseFavorites(fileData []byte) ([]Station, bool, error) {
+	version, err := favoritesVersion(fileData)
+	if err != nil {
+		return nil, false, err
+	}
+	if version > FAVORITES_VERSION {
+		return nil, false, fmt.Errorf("%w: version %d", ErrNewerFavorites, version)
+	}
+	migrated := version < FAVORITES_VERSION
+	for ; version < FAVORITES_VERSION; version++ {
+		fileData, err = FAVORITES_MIGRATIONS[version](fileData)
+		if err != nil {
+			return nil, false, fmt.Errorf("Migrating from version %d: %w", version, err)
+		}
+	}
+	file := favoritesFile{}
+	if err := json.Unmarshal(fileData, &file); err != nil {
+		return nil, false, err
+	}
+	for i, station := range file.Stations {
+		if station.UUID == "" || station.URL == "" {
+			return nil, false, fmt.Errorf("Station %d has no UUID or URL", i)
+		}
+	}
+	return file.Stations, migrated, nil
+}
+
+func favoritesVersion(fileData []byte) (int, error) {
+	var raw interface{}
+	if err := json.Unmarshal(fileData, &raw); err != nil {
+		return 0, err
+	}
+	switch raw := raw.(type) {
+	case []interface{}:
+		return 0, nil
+	case map[string]interface{}:
+		version, ok := raw["version"].(float64)
+		if !ok {
+			return 0, errors.New("No version")
+		}
+		return int(version), nil
+	}
+	return 0, errors.New("Not a list of favorites")
+}
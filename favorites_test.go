@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFavoritesStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "favstations.json")
+	store := NewFavoritesStore(path)
+	if stations := store.Load(); len(stations) != len(DEFAULT_FAVORITES) {
+		t.Fatalf("Expected the defaults without a file, got %v", stations)
+	}
+
+	// A file from before the schema is upgraded in place
+	legacy, _ := json.Marshal([]Station{testStationA})
+	os.WriteFile(path, legacy, 0644)
+	if stations := store.Load(); len(stations) != 1 || stations[0].UUID != testStationA.UUID {
+		t.Fatalf("Legacy favorites not loaded: %v", stations)
+	}
+	fileData, _ := os.ReadFile(path)
+	if version, _ := favoritesVersion(fileData); version != FAVORITES_VERSION {
+		t.Errorf("Expected version %d on disk, got %d", FAVORITES_VERSION, version)
+	}
+
+	// Every save keeps the previous good file
+	if err := store.Save([]Station{testStationA, testStationB}); err != nil {
+		t.Fatal(err)
+	}
+	backup, _, err := readFavorites(store.backupPath())
+	if err != nil || len(backup) != 1 {
+		t.Fatalf("Expected a backup with A, got %v %v", backup, err)
+	}
+
+	// A torn write falls back on the backup, and puts it back in place
+	os.WriteFile(path, []byte(`{"version": 1, "stati`), 0644)
+	if stations := store.Load(); len(stations) != 1 || stations[0].UUID != testStationA.UUID {
+		t.Fatalf("Expected the backup, got %v", stations)
+	}
+	if _, _, err := readFavorites(path); err != nil {
+		t.Errorf("Backup was not restored: %s", err)
+	}
+
+	// Both broken, the radio still plays something
+	os.WriteFile(path, []byte{0, 0, 0}, 0644)
+	os.WriteFile(store.backupPath(), []byte(`[{"name": "no uuid"}]`), 0644)
+	if stations := store.Load(); len(stations) != len(DEFAULT_FAVORITES) {
+		t.Errorf("Expected the defaults, got %v", stations)
+	}
+}
+
+func TestFavoritesFromTheFuture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "favstations.json")
+	future := []byte(`{"version": 99, "stations": [], "playlists": []}`)
+	os.WriteFile(path, future, 0644)
+	defer func(file string) { FAVORITES_FILE = file }(FAVORITES_FILE)
+	FAVORITES_FILE = path
+
+	getFavoriteStations()
+	if err := saveFavoriteStations([]Station{testStationA}); !errors.Is(err, ErrNewerFavorites) {
+		t.Errorf("Expected ErrNewerFavorites, got %v", err)
+	}
+	if fileData, _ := os.ReadFile(path); string(fileData) != string(future) {
+		t.Error("Favorites from a newer version were overwritten")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// The first check waits for the boot station to settle
+	FAVORITES_WATCH_DELAY    = time.Minute
+	FAVORITES_WATCH_INTERVAL = 6 * time.Hour
+
+	// A probe reads this much of a stream, a playlist or HLS index counts
+	PROBE_BYTES   = 1024
+	PROBE_TIMEOUT = 10 * time.Second
+	// Streams never end, PROBE_TIMEOUT ends the request instead
+	PROBE_CLIENT = &http.Client{}
+)
+
+// ErrNotProbed is for streams that aren't plain HTTP, ffmpeg may still
+// play them but we can't tell
+var ErrNotProbed = errors.New("Not an HTTP stream")
+
+// FavoritesWatch keeps the favorites in step with radio-browser, so a
+// broadcaster moving its stream doesn't kill a favorite, and probes their
+// streams so `next_favorite` and `play_favorite` can skip the dead ones.
+// All methods are safe to call on nil.
+type FavoritesWatch struct {
+	Lookup    func(uuid string) (Station, error)
+	Stats     *Reliability
+	Favorites func() []Station
+	Update    func(station Station)
+	Probe     func(station Station) error
+
+	done    chan bool
+	stopped sync.Once
+}
+
+func NewFavoritesWatch(stats *Reliability, radio *Radio) *FavoritesWatch {
+	return &FavoritesWatch{
+		Lookup:    get_station_by_uuid,
+		Stats:     stats,
+		Favorites: radio.ListFavorites,
+		Update:    radio.UpdateFavorite,
+		Probe:     ProbeStream,
+		done:      make(chan bool),
+	}
+}
+
+// Run checks the favorites every FAVORITES_WATCH_INTERVAL until Close
+func (watch *FavoritesWatch) Run() {
+	if watch == nil {
+		return
+	}
+	timer := time.NewTimer(FAVORITES_WATCH_DELAY)
+	defer timer.Stop()
+	for {
+		select {
+		case <-watch.done:
+			return
+		case <-timer.C:
+			watch.Check()
+			timer.Reset(FAVORITES_WATCH_INTERVAL)
+		}
+	}
+}
+
+// Check refreshes every favorite through its UUID, then probes it
+func (watch *FavoritesWatch) Check() {
+	if watch == nil {
+		return
+	}
+	favorites := watch.Favorites()
+	fmt.Printf("[WATCH] Checking %d favorites\n", len(favorites))
+	for _, favorite := range favorites {
+		select {
+		case <-watch.done:
+			return
+		default:
+		}
+		favorite = watch.refresh(favorite)
+		err := watch.Probe(favorite)
+		if errors.Is(err, ErrNotProbed) {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("[WATCH] [%s] %s\n", favorite.Name, err)
+		}
+		watch.Stats.Probed(favorite, err == nil)
+	}
+}
+
+// refresh updates the name and stream of `favorite` if radio-browser has
+// new ones
+func (watch *FavoritesWatch) refresh(favorite Station) Station {
+	if strings.HasPrefix(favorite.UUID, LOCAL_UUID_PREFIX) {
+		return favorite
+	}
+	fresh, err := watch.Lookup(favorite.UUID)
+	if err != nil {
+		fmt.Printf("[WATCH] [%s] %s\n", favorite.Name, err)
+		return favorite
+	}
+	updated, changed := refreshed(favorite, fresh)
+	if changed {
+		fmt.Printf("[WATCH] [%s] Now %s at %s\n", favorite.Name, updated.Name, updated.URL)
+		watch.Update(updated)
+	}
+	return updated
+}
+
+// refreshed is `favorite` with the name and URL of `fresh`, everything
+// else is ours to keep
+func refreshed(favorite Station, fresh Station) (Station, bool) {
+	updated := favorite
+	if fresh.Name != "" {
+		updated.Name = fresh.Name
+	}
+	if fresh.URL != "" {
+		updated.URL = fresh.URL
+	}
+	return updated, updated.Name != favorite.Name || updated.URL != favorite.URL
+}
+
+func (watch *FavoritesWatch) Close() {
+	if watch == nil {
+		return
+	}
+	watch.stopped.Do(func() { close(watch.done) })
+}
+
+// ProbeStream is nil if one of the station's streams sends data. A stream
+// is connected to once, a playlist is fetched and its streams probed in
+// order until one answers, as NewStationStream would try them.
+func ProbeStream(station Station) error {
+	err := ErrNotProbed
+	for _, candidate := range station.StreamURLs() {
+		for _, streamURL := range ResolveStreamURLs(candidate) {
+			probeErr := probe(streamURL)
+			if probeErr == nil {
+				return nil
+			}
+			if !errors.Is(probeErr, ErrNotProbed) {
+				err = probeErr
+			}
+		}
+	}
+	return err
+}
+
+func probe(streamURL string) error {
+	u, err := url.Parse(streamURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ErrNotProbed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), PROBE_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	res, err := PROBE_CLIENT.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("[%s] %s", streamURL, res.Status)
+	}
+	n, err := io.ReadFull(res.Body, make([]byte, PROBE_BYTES))
+	if n == 0 {
+		return fmt.Errorf("[%s] No data: %v", streamURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFavoritesWatch(t *testing.T) {
+	favorites := []Station{testStationA, testStationB, {Name: "Local", UUID: LOCAL_UUID_PREFIX + "1", URL: "rtmp://local"}}
+	updated := []Station{}
+	probed := []string{}
+	watch := &FavoritesWatch{
+		Lookup: func(uuid string) (Station, error) {
+			switch uuid {
+			case testStationA.UUID:
+				return Station{Name: "A Radio", UUID: uuid, URL: "http://a/moved"}, nil
+			case testStationB.UUID:
+				return testStationB, nil
+			}
+			t.Errorf("Looked up %s", uuid)
+			return Station{}, errors.New("No station matching UUID: " + uuid)
+		},
+		Stats:     LoadReliability(filepath.Join(t.TempDir(), "reliability.json")),
+		Favorites: func() []Station { return favorites },
+		Update:    func(station Station) { updated = append(updated, station) },
+		Probe: func(station Station) error {
+			probed = append(probed, station.URL)
+			switch station.URL {
+			case "http://a/moved":
+				return nil
+			case "rtmp://local":
+				return ErrNotProbed
+			}
+			return errors.New("404 Not Found")
+		},
+		done: make(chan bool),
+	}
+	for i := 0; i < RELIABILITY_DEAD_PROBES; i++ {
+		watch.Check()
+	}
+
+	if len(updated) != RELIABILITY_DEAD_PROBES || updated[0].Name != "A Radio" || updated[0].URL != "http://a/moved" {
+		t.Errorf("Expected A to move, got %v", updated)
+	}
+	if len(probed) != 3*RELIABILITY_DEAD_PROBES || probed[0] != "http://a/moved" {
+		t.Errorf("Expected the new URL of A probed, got %v", probed)
+	}
+	if watch.Stats.Get(testStationA.UUID).Dead() || !watch.Stats.Get(testStationB.UUID).Dead() {
+		t.Errorf("Expected only B dead")
+	}
+	if stats := watch.Stats.Get(LOCAL_UUID_PREFIX + "1"); !stats.LastProbe.IsZero() {
+		t.Errorf("Recorded a probe that never happened: %v", stats)
+	}
+
+	// Closed, it does nothing
+	watch.Close()
+	watch.Check()
+	if len(probed) != 3*RELIABILITY_DEAD_PROBES {
+		t.Errorf("Probed after Close")
+	}
+}
+
+func TestProbeStream(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Telling streams from playlists, not a connection to the stream
+			if r.URL.Path == "/stream" || r.URL.Path == "/empty" {
+				w.Header().Set("Content-Type", "audio/mpeg")
+			} else {
+				http.NotFound(w, r)
+			}
+			return
+		}
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/station.pls":
+			w.Write([]byte("[playlist]\nFile1=/stream\nFile2=/gone\n"))
+		case "/dead-first.pls":
+			w.Write([]byte("[playlist]\nFile1=/gone\nFile2=/stream\n"))
+		case "/stream":
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write(make([]byte, PROBE_BYTES))
+			w.(http.Flusher).Flush()
+			// A real stream never ends, the probe must not wait for it
+			<-r.Context().Done()
+		case "/empty":
+			w.Header().Set("Content-Type", "audio/mpeg")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	if err := ProbeStream(Station{URL: server.URL + "/stream"}); err != nil {
+		t.Errorf("Expected the stream alive, got %s", err)
+	}
+	// One connection to the stream, one to the playlist and its first stream
+	if err := ProbeStream(Station{URL: server.URL + "/station.pls"}); err != nil {
+		t.Errorf("Expected the playlist alive, got %s", err)
+	}
+	mu.Lock()
+	if requests["/stream"] != 2 || requests["/station.pls"] != 1 || requests["/gone"] != 0 {
+		t.Errorf("Unexpected requests: %v", requests)
+	}
+	mu.Unlock()
+	// A dead first entry doesn't make the playlist dead
+	if err := ProbeStream(Station{URL: server.URL + "/dead-first.pls"}); err != nil {
+		t.Errorf("Expected the second entry alive, got %s", err)
+	}
+	mu.Lock()
+	if requests["/gone"] != 1 || requests["/stream"] != 3 {
+		t.Errorf("Unexpected requests: %v", requests)
+	}
+	mu.Unlock()
+	// The backup URL answers when the main one doesn't
+	if err := ProbeStream(Station{URL: server.URL + "/gone", URLs: []string{server.URL + "/stream"}}); err != nil {
+		t.Errorf("Expected the backup alive, got %s", err)
+	}
+	if err := ProbeStream(Station{URL: server.URL + "/empty"}); err == nil {
+		t.Errorf("Expected an empty stream dead")
+	}
+	if err := ProbeStream(Station{URL: server.URL + "/gone"}); err == nil || errors.Is(err, ErrNotProbed) {
+		t.Errorf("Expected a 404, got %v", err)
+	}
+	if err := ProbeStream(Station{URL: "rtmp://elsewhere"}); !errors.Is(err, ErrNotProbed) {
+		t.Errorf("Expected rtmp not probed, got %v", err)
+	}
+}
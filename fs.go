@@ -5,34 +5,92 @@ import (
 	"os"
 )
 
-var FAVORITES_FILE = "favstations.json"
+var (
+	FAVORITES_FILE = "favstations.json"
+	PRESETS_FILE   = "presets.json"
+)
+
+// Positions on the 8421 preset dial
+const PRESET_SLOTS = 16
 
 func getFavoriteStations() []Station {
-	fileData, err := os.ReadFile(FAVORITES_FILE)
+	return NewFavoritesStore(FAVORITES_FILE).Load()
+}
+
+func saveFavoriteStations(stations []Station) error {
+	return NewFavoritesStore(FAVORITES_FILE).Save(stations)
+}
+
+// Presets maps a position of the preset dial to the UUID of a favorite.
+// Slots are only handed out or freed, never shuffled, so a favorite stays
+// on the same position when others are added or removed.
+type Presets map[int]string
+
+func getPresets() Presets {
+	presets := Presets{}
+	fileData, err := os.ReadFile(PRESETS_FILE)
 	if err != nil {
-		return []Station{
-			{"BBC One",
-				"0af24a33-1631-4c23-b09a-c1413d2c4fb0",
-				"http://as-hls-ww-live.akamaized.net/pool_904/live/ww/bbc_radio_one/bbc_radio_one.isml/bbc_radio_one-audio%3d96000.norewind.m3u8",
-				"pop",
-			},
-			{
-				"106,7 Rockklassiker",
-				"9642ad8b-0601-11e8-ae97-52543be04c81",
-				"http://edge-bauerse-02-thn.sharp-stream.com/rockklassiker_instream_se_mp3?ua=WEB&",
-				"classic rock",
-			},
-		}
+		return presets
 	}
-	stations := []Station{}
-	json.Unmarshal(fileData, &stations)
-	return stations
+	json.Unmarshal(fileData, &presets)
+	return presets
 }
 
-func saveFavoriteStations(stations []Station) error {
-	fileData, err := json.Marshal(stations)
+func savePresets(presets Presets) error {
+	fileData, err := json.Marshal(presets)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(FAVORITES_FILE, fileData, 0644)
+	return WriteFileAtomic(PRESETS_FILE, fileData)
+}
+
+// Assign frees the slots of stations that are no longer favorites and gives
+// every favorite without a slot the lowest free one. Returns true if anything changed.
+func (presets Presets) Assign(favorites []Station) bool {
+	changed := false
+	isFavorite := map[string]bool{}
+	for _, station := range favorites {
+		// A slot holding "" would look free
+		if station.UUID != "" {
+			isFavorite[station.UUID] = true
+		}
+	}
+	hasSlot := map[string]bool{}
+	for slot, uuid := range presets {
+		if !isFavorite[uuid] || slot < 0 || slot >= PRESET_SLOTS {
+			delete(presets, slot)
+			changed = true
+			continue
+		}
+		hasSlot[uuid] = true
+	}
+	slot := 0
+	for _, station := range favorites {
+		if station.UUID == "" || hasSlot[station.UUID] {
+			continue
+		}
+		for slot < PRESET_SLOTS && presets[slot] != "" {
+			slot++
+		}
+		if slot == PRESET_SLOTS {
+			break
+		}
+		presets[slot] = station.UUID
+		hasSlot[station.UUID] = true
+		changed = true
+	}
+	return changed
+}
+
+func (presets Presets) Station(slot int, favorites []Station) (Station, bool) {
+	uuid, ok := presets[slot]
+	if !ok {
+		return Station{}, false
+	}
+	for _, station := range favorites {
+		if station.UUID == uuid {
+			return station, true
+		}
+	}
+	return Station{}, false
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testFavorites(names ...string) []Station {
+	stations := []Station{}
+	for _, name := range names {
+		uuid := ""
+		if name != "" {
+			uuid = "uuid-" + name
+		}
+		stations = append(stations, Station{Name: name, UUID: uuid, URL: "http://" + name})
+	}
+	return stations
+}
+
+func TestPresetsAssign(t *testing.T) {
+	many := []string{}
+	manyPresets := Presets{}
+	for i := 0; i < PRESET_SLOTS+2; i++ {
+		many = append(many, fmt.Sprint(i))
+		if i < PRESET_SLOTS {
+			manyPresets[i] = fmt.Sprintf("uuid-%d", i)
+		}
+	}
+	afterRemove := Presets{}
+	for slot, uuid := range manyPresets {
+		afterRemove[slot] = uuid
+	}
+	afterRemove[3] = fmt.Sprintf("uuid-%d", PRESET_SLOTS)
+
+	for _, test := range []struct {
+		name      string
+		presets   Presets
+		favorites []Station
+		expected  Presets
+		changed   bool
+	}{
+		{"first boot", Presets{}, testFavorites("a", "b", "c"),
+			Presets{0: "uuid-a", 1: "uuid-b", 2: "uuid-c"}, true},
+		{"add", Presets{0: "uuid-a", 1: "uuid-b"}, testFavorites("a", "b", "c"),
+			Presets{0: "uuid-a", 1: "uuid-b", 2: "uuid-c"}, true},
+		{"remove keeps the others in place", Presets{0: "uuid-a", 1: "uuid-b", 2: "uuid-c"}, testFavorites("a", "c"),
+			Presets{0: "uuid-a", 2: "uuid-c"}, true},
+		{"add fills the lowest hole", Presets{0: "uuid-a", 2: "uuid-c"}, testFavorites("a", "c", "d"),
+			Presets{0: "uuid-a", 1: "uuid-d", 2: "uuid-c"}, true},
+		{"reorder changes nothing", Presets{0: "uuid-a", 1: "uuid-b", 2: "uuid-c"}, testFavorites("c", "a", "b"),
+			Presets{0: "uuid-a", 1: "uuid-b", 2: "uuid-c"}, false},
+		{"more than 16", Presets{}, testFavorites(many...), manyPresets, true},
+		{"a removal makes room for the 17th", manyPresets, testFavorites(append(append([]string{}, many[:3]...), many[4:]...)...),
+			afterRemove, true},
+		{"no UUID, no slot", Presets{}, testFavorites("a", "", "b"),
+			Presets{0: "uuid-a", 1: "uuid-b"}, true},
+		{"an empty slot is freed", Presets{0: "", 1: "uuid-b"}, testFavorites("a", "b"),
+			Presets{0: "uuid-a", 1: "uuid-b"}, true},
+		{"slots off the dial are freed", Presets{PRESET_SLOTS: "uuid-a"}, testFavorites("a"),
+			Presets{0: "uuid-a"}, true},
+	} {
+		presets := Presets{}
+		for slot, uuid := range test.presets {
+			presets[slot] = uuid
+		}
+		changed := presets.Assign(test.favorites)
+		if changed != test.changed {
+			t.Errorf("%s: expected changed %t", test.name, test.changed)
+		}
+		if !reflect.DeepEqual(presets, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, presets)
+		}
+	}
+}
+
+func TestPresetsSaved(t *testing.T) {
+	defer func(path string) { PRESETS_FILE = path }(PRESETS_FILE)
+	PRESETS_FILE = filepath.Join(t.TempDir(), "presets.json")
+	if presets := getPresets(); len(presets) != 0 {
+		t.Errorf("Expected no presets without a file, got %v", presets)
+	}
+	if err := savePresets(Presets{3: "uuid-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if presets := getPresets(); presets[3] != "uuid-a" || len(presets) != 1 {
+		t.Errorf("Unexpected presets %v", presets)
+	}
+}
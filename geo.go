@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// Places are where `play_nearby` travels to, set in `config.json`
+var PLACES = []Place{}
+
+// How far from a place a station may be when the place doesn't say
+const DEFAULT_PLACE_RADIUS = 100.0 // km
+
+const EARTH_RADIUS = 6371.0 // km
+
+type Place struct {
+	Name   string  `json:"name"`
+	Lat    float64 `json:"lat"`
+	Long   float64 `json:"long"`
+	Radius float64 `json:"radius_km"`
+}
+
+func (place Place) radius() float64 {
+	if place.Radius > 0 {
+		return place.Radius
+	}
+	return DEFAULT_PLACE_RADIUS
+}
+
+// Distance from the place to the station in km, the great circle one
+func (place Place) Distance(station Station) float64 {
+	return Haversine(place.Lat, place.Long, station.GeoLat, station.GeoLong)
+}
+
+func (place Place) Near(station Station) bool {
+	return station.HasGeo() && place.Distance(station) <= place.radius()
+}
+
+func Haversine(lat1, long1, lat2, long2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLong := (long2 - long1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(a))
+}
+
+// get_station_nearby picks a station close to one of PLACES
+func get_station_nearby(currentStation Station, favorites []Station) (Station, error) {
+	if len(PLACES) == 0 {
+		return Station{}, errors.New("No places in config.json")
+	}
+	place := PickOne(PLACES)
+	fmt.Printf("[NEARBY] Travelling to %s\n", place.Name)
+
+	// Where we are going, we don't need languages or tags
+	filters := SEARCH_FILTERS
+	filters.Tags, filters.Countries = nil, nil
+	query := station_search_query(50, "random", "", filters)
+	query.Set("geo_lat", fmt.Sprint(place.Lat))
+	query.Set("geo_long", fmt.Sprint(place.Long))
+	query.Set("geo_distance", fmt.Sprint(int(place.radius()*1000)))
+	query.Set("has_geo_info", "true")
+	stations, err := RADIO_BROWSER.Search(query)
+	if err != nil {
+		log.Printf("[%s] Failed: %s", place.Name, err)
+	}
+	candidates := []Station{}
+	for _, station := range stations {
+		// Mirrors without geo search ignore it, so check for ourselves
+		if station.UUID != currentStation.UUID && place.Near(station) && filters.Allows(station) {
+			candidates = append(candidates, station)
+		}
+	}
+	// After the search, so the fresh copy of a station is the one kept
+	if CATALOG != nil {
+		for _, station := range CATALOG.Candidates(currentStation, CATALOG.Languages(), filters) {
+			if place.Near(station) {
+				candidates = append(candidates, station)
+			}
+		}
+	}
+
+	if station, ok := RELIABILITY.Pick(dedupeStations(candidates)); ok {
+		return station, nil
+	}
+	if err != nil {
+		return Station{}, err
+	}
+	return Station{}, fmt.Errorf("No stations near %s", place.Name)
+}
+
+// DescribeNearby is the card shown when a station near a place plays: its
+// name and how far it is from the closest place, or its country
+func DescribeNearby(station Station) Info {
+	lines := []string{station.Name}
+	if station.HasGeo() && len(PLACES) > 0 {
+		closest := PLACES[0]
+		for _, place := range PLACES[1:] {
+			if place.Distance(station) < closest.Distance(station) {
+				closest = place
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%.0f KM FROM", closest.Distance(station)), closest.Name)
+	}
+	if station.Country != "" {
+		lines = append(lines, station.Country)
+	}
+	return Info{Lines: lines}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var (
+	testBerlin = Place{Name: "Berlin", Lat: 52.52, Long: 13.405, Radius: 50}
+	testParis  = Place{Name: "Paris", Lat: 48.8566, Long: 2.3522}
+)
+
+func TestHaversine(t *testing.T) {
+	d := Haversine(testBerlin.Lat, testBerlin.Long, testParis.Lat, testParis.Long)
+	if math.Abs(d-878) > 5 {
+		t.Errorf("Berlin to Paris is about 878 km, got %.0f", d)
+	}
+	if !testBerlin.Near(Station{GeoLat: 52.4, GeoLong: 13.1}) {
+		t.Error("Potsdam is near Berlin")
+	}
+	if testBerlin.Near(Station{}) {
+		t.Error("A station without a location is near nothing")
+	}
+}
+
+func TestStationNearby(t *testing.T) {
+	queries := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		// This mirror ignores geo search, Paris has to be filtered out
+		w.Write([]byte(`[
+			{"stationuuid": "uuid-potsdam", "name": "Potsdam FM", "geo_lat": 52.4, "geo_long": 13.1, "country": "Germany"},
+			{"stationuuid": "uuid-paris", "name": "Paris FM", "geo_lat": 48.85, "geo_long": 2.35}
+		]`))
+	}))
+	defer server.Close()
+	defer func(rb *RadioBrowser, catalog *Catalog, places []Place) {
+		RADIO_BROWSER, CATALOG, PLACES = rb, catalog, places
+	}(RADIO_BROWSER, CATALOG, PLACES)
+	RADIO_BROWSER = newTestRadioBrowser(server)
+	CATALOG = nil
+	PLACES = []Place{testBerlin}
+
+	for i := 0; i < 5; i++ {
+		station, err := get_station_nearby(Station{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if station.UUID != "uuid-potsdam" {
+			t.Fatalf("Travelled to %s", station.Name)
+		}
+		if query := <-queries; query == "" {
+			t.Error("Empty query")
+		}
+	}
+
+	// The catalog adds its stations in any language, with any tags, but the
+	// search has the fresh copy
+	defer func(filters SearchFilters, languages []string) {
+		SEARCH_FILTERS, LANGUAGES = filters, languages
+	}(SEARCH_FILTERS, LANGUAGES)
+	SEARCH_FILTERS = SearchFilters{Tags: []string{"jazz"}}
+	LANGUAGES = []string{"english"}
+	CATALOG = newTestCatalog(t)
+	CATALOG.stations["german"] = []Station{
+		{UUID: "uuid-potsdam", Name: "Potsdam FM (old)", GeoLat: 52.4, GeoLong: 13.1},
+		{UUID: "uuid-berlin", Name: "Berlin Talk", Tags: "talk", GeoLat: 52.5, GeoLong: 13.4},
+	}
+	picked := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		station, err := get_station_nearby(Station{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[station.Name] = true
+		<-queries
+	}
+	if !reflect.DeepEqual(picked, map[string]bool{"Potsdam FM": true, "Berlin Talk": true}) {
+		t.Errorf("Picked %v", picked)
+	}
+
+	info := DescribeNearby(Station{Name: "Potsdam FM", GeoLat: 52.4, GeoLong: 13.1, Country: "Germany"})
+	expected := []string{"Potsdam FM", "25 KM FROM", "Berlin", "Germany"}
+	if len(info.Lines) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, info.Lines)
+	}
+	for i := range expected {
+		if info.Lines[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, info.Lines)
+		}
+	}
+	if _, err := drawInfo(info); err != nil {
+		t.Error(err)
+	}
+}
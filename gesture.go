@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type GestureTimings struct {
+	// A button released before `Hold` is a press, after it a hold
+	Hold time.Duration
+	// Held for `LongHold` fires LONG_HOLD while the button is still down.
+	// With LongHold set, HOLD fires on release so the two don't overlap.
+	// Set to 0 to fire HOLD as soon as `Hold` has passed.
+	LongHold time.Duration
+	// How long to wait for a second press. Set to 0 to disable DOUBLE_PRESS,
+	// which also stops PRESS from being delayed.
+	DoublePress time.Duration
+	// Once held for `Hold`, REPEAT fires every `Repeat` until release
+	Repeat time.Duration
+}
+
+var DEFAULT_GESTURE_TIMINGS = GestureTimings{
+	Hold:        500 * time.Millisecond,
+	LongHold:    2 * time.Second,
+	DoublePress: 300 * time.Millisecond,
+	Repeat:      250 * time.Millisecond,
+}
+
+var GESTURE_TIMINGS = DEFAULT_GESTURE_TIMINGS
+
+type buttonState struct {
+	down     bool
+	downAt   time.Time
+	shift    bool // SHIFT was down when this button went down
+	fired    bool // HOLD or LONG_HOLD already fired while held
+	consumed bool // part of a chord, the release is ignored
+	pending  bool // released once, waiting to see if a second press follows
+	second   bool // this is the second press of a possible DOUBLE_PRESS
+	gen      int  // bumped on every edge so stale timers are ignored
+}
+
+// GestureRecognizer turns raw button edges into gestures. SHIFT is a
+// modifier and sets `Shift` on the gesture, any other button held down
+// while a second one is pressed makes a CHORD.
+type GestureRecognizer struct {
+	Timings GestureTimings
+	events  chan InputEvent
+	mu      sync.Mutex
+	buttons map[int]*buttonState
+	closed  bool
+
+	// The clock, swapped out in tests
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func())
+}
+
+func NewGestureRecognizer(timings GestureTimings) *GestureRecognizer {
+	return &GestureRecognizer{
+		Timings: timings,
+		events:  make(chan InputEvent, 16),
+		buttons: map[int]*buttonState{},
+		now:     time.Now,
+		afterFunc: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+func (g *GestureRecognizer) Events() <-chan InputEvent {
+	return g.events
+}
+
+func (g *GestureRecognizer) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.closed {
+		g.closed = true
+		close(g.events)
+	}
+	return nil
+}
+
+// Send passes an already recognised event through, e.g. from an encoder
+func (g *GestureRecognizer) Send(ev InputEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.emit(ev)
+}
+
+// Must be called with g.mu held
+func (g *GestureRecognizer) emit(ev InputEvent) {
+	if g.closed {
+		return
+	}
+	select {
+	case g.events <- ev:
+	default:
+		// Nobody is listening, dropping a gesture beats stalling the buttons
+		fmt.Printf("[INPUT] Dropped %s\n", ev)
+	}
+}
+
+func (g *GestureRecognizer) button(pin int) *buttonState {
+	b, ok := g.buttons[pin]
+	if !ok {
+		b = &buttonState{}
+		g.buttons[pin] = b
+	}
+	return b
+}
+
+func (g *GestureRecognizer) shiftDown() bool {
+	return g.button(BTN_SHIFT).down
+}
+
+// Down records that `pin` was pressed
+func (g *GestureRecognizer) Down(pin int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.button(pin)
+	if b.down {
+		return
+	}
+	shift := g.shiftDown()
+	if pin != BTN_SHIFT {
+		// SHIFT has done its job as a modifier, releasing it is not a press
+		if shift {
+			g.button(BTN_SHIFT).consumed = true
+		}
+		for other, ob := range g.buttons {
+			if other == pin || other == BTN_SHIFT || !ob.down || ob.consumed {
+				continue
+			}
+			ob.consumed = true
+			b.down = true
+			b.consumed = true
+			b.gen++
+			g.emit(InputEvent{Pin: pin, Kind: CHORD, Shift: shift, Value: other})
+			return
+		}
+	}
+	b.down = true
+	b.downAt = g.now()
+	b.shift = shift
+	b.fired = false
+	b.consumed = false
+	b.second = b.pending
+	b.pending = false
+	b.gen++
+	gen := b.gen
+	if g.Timings.LongHold > 0 {
+		g.afterFunc(g.Timings.LongHold, func() { g.longHold(pin, gen) })
+	} else {
+		g.afterFunc(g.Timings.Hold, func() { g.hold(pin, gen) })
+	}
+	if g.Timings.Repeat > 0 {
+		g.afterFunc(g.Timings.Hold, func() { g.repeat(pin, gen) })
+	}
+}
+
+// Up records that `pin` was released
+func (g *GestureRecognizer) Up(pin int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.button(pin)
+	if !b.down {
+		return
+	}
+	b.down = false
+	b.gen++
+	if b.consumed || b.fired {
+		b.second = false
+		return
+	}
+	if g.now().Sub(b.downAt) >= g.Timings.Hold {
+		if b.second {
+			g.emit(InputEvent{Pin: pin, Kind: PRESS, Shift: b.shift})
+		}
+		b.second = false
+		g.emit(InputEvent{Pin: pin, Kind: HOLD, Shift: b.shift})
+		return
+	}
+	if b.second {
+		b.second = false
+		g.emit(InputEvent{Pin: pin, Kind: DOUBLE_PRESS, Shift: b.shift})
+		return
+	}
+	if g.Timings.DoublePress == 0 {
+		g.emit(InputEvent{Pin: pin, Kind: PRESS, Shift: b.shift})
+		return
+	}
+	b.pending = true
+	gen := b.gen
+	g.afterFunc(g.Timings.DoublePress, func() { g.single(pin, gen) })
+}
+
+func (g *GestureRecognizer) hold(pin int, gen int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.button(pin)
+	if b.gen != gen || !b.down || b.consumed {
+		return
+	}
+	if b.second {
+		b.second = false
+		g.emit(InputEvent{Pin: pin, Kind: PRESS, Shift: b.shift})
+	}
+	b.fired = true
+	g.emit(InputEvent{Pin: pin, Kind: HOLD, Shift: b.shift})
+}
+
+func (g *GestureRecognizer) longHold(pin int, gen int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.button(pin)
+	if b.gen != gen || !b.down || b.consumed {
+		return
+	}
+	if b.second {
+		b.second = false
+		g.emit(InputEvent{Pin: pin, Kind: PRESS, Shift: b.shift})
+	}
+	b.fired = true
+	g.emit(InputEvent{Pin: pin, Kind: LONG_HOLD, Shift: b.shift})
+}
+
+func (g *GestureRecognizer) repeat(pin int, gen int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.button(pin)
+	if b.gen != gen || !b.down || b.consumed {
+		return
+	}
+	g.emit(InputEvent{Pin: pin, Kind: REPEAT, Shift: b.shift})
+	g.afterFunc(g.Timings.Repeat, func() { g.repeat(pin, gen) })
+}
+
+// single fires the PRESS held back while waiting for a DOUBLE_PRESS
+func (g *GestureRecognizer) single(pin int, gen int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := g.button(pin)
+	if b.gen != gen || !b.pending {
+		return
+	}
+	b.pending = false
+	g.emit(InputEvent{Pin: pin, Kind: PRESS, Shift: b.shift})
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testTimings = GestureTimings{
+	Hold:        40 * time.Millisecond,
+	LongHold:    150 * time.Millisecond,
+	DoublePress: 30 * time.Millisecond,
+	Repeat:      20 * time.Millisecond,
+}
+
+type fakeTimer struct {
+	at time.Time
+	f  func()
+}
+
+// fakeClock only moves on Advance, which runs the timers that came due
+type fakeClock struct {
+	now    time.Time
+	timers []fakeTimer
+}
+
+func (c *fakeClock) afterFunc(d time.Duration, f func()) {
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), f: f})
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = timer.at
+		timer.f()
+	}
+	c.now = end
+}
+
+func newTestGestures(timings GestureTimings) (*GestureRecognizer, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	g := NewGestureRecognizer(timings)
+	g.now = func() time.Time { return clock.now }
+	g.afterFunc = clock.afterFunc
+	return g, clock
+}
+
+func nextGesture(t *testing.T, g *GestureRecognizer) InputEvent {
+	t.Helper()
+	select {
+	case ev := <-g.Events():
+		return ev
+	default:
+		t.Fatal("No gesture")
+	}
+	return InputEvent{}
+}
+
+func noGesture(t *testing.T, g *GestureRecognizer) {
+	t.Helper()
+	select {
+	case ev := <-g.Events():
+		t.Fatalf("Unexpected gesture: %s", ev)
+	default:
+	}
+}
+
+func TestGesturePress(t *testing.T) {
+	g, clock := newTestGestures(testTimings)
+	g.Down(TEST_BTN_Y)
+	g.Up(TEST_BTN_Y)
+	// Held back in case a second press follows
+	noGesture(t, g)
+	clock.Advance(testTimings.DoublePress)
+	ev := nextGesture(t, g)
+	if ev.Pin != TEST_BTN_Y || ev.Kind != PRESS || ev.Shift {
+		t.Errorf("Expected PRESS, got %s", ev)
+	}
+}
+
+func TestGestureDoublePress(t *testing.T) {
+	g, clock := newTestGestures(testTimings)
+	g.Down(TEST_BTN_Y)
+	g.Up(TEST_BTN_Y)
+	clock.Advance(testTimings.DoublePress / 2)
+	g.Down(TEST_BTN_Y)
+	g.Up(TEST_BTN_Y)
+	ev := nextGesture(t, g)
+	if ev.Kind != DOUBLE_PRESS {
+		t.Errorf("Expected DOUBLE_PRESS, got %s", ev)
+	}
+	clock.Advance(2 * testTimings.DoublePress)
+	noGesture(t, g)
+}
+
+func TestGestureHoldAndRepeat(t *testing.T) {
+	timings := testTimings
+	timings.Repeat = 0
+	g, clock := newTestGestures(timings)
+	g.Down(TEST_BTN_X)
+	clock.Advance(2 * timings.Hold)
+	// With LongHold set, HOLD waits for the release
+	noGesture(t, g)
+	g.Up(TEST_BTN_X)
+	ev := nextGesture(t, g)
+	if ev.Kind != HOLD {
+		t.Errorf("Expected HOLD, got %s", ev)
+	}
+
+	g, clock = newTestGestures(testTimings)
+	g.Down(TEST_BTN_X)
+	clock.Advance(testTimings.Hold - time.Millisecond)
+	noGesture(t, g)
+	clock.Advance(time.Millisecond + testTimings.Repeat)
+	for i := 0; i < 2; i++ {
+		if ev := nextGesture(t, g); ev.Kind != REPEAT {
+			t.Errorf("Expected REPEAT, got %s", ev)
+		}
+	}
+	noGesture(t, g)
+	g.Up(TEST_BTN_X)
+	clock.Advance(testTimings.Repeat)
+	if ev := nextGesture(t, g); ev.Kind != HOLD {
+		t.Errorf("Expected HOLD on release, got %s", ev)
+	}
+	noGesture(t, g)
+}
+
+func TestGestureLongHold(t *testing.T) {
+	timings := testTimings
+	timings.Repeat = 0
+	g, clock := newTestGestures(timings)
+	g.Down(TEST_BTN_X)
+	clock.Advance(timings.LongHold)
+	ev := nextGesture(t, g)
+	if ev.Kind != LONG_HOLD {
+		t.Errorf("Expected LONG_HOLD, got %s", ev)
+	}
+	g.Up(TEST_BTN_X)
+	clock.Advance(2 * timings.DoublePress)
+	noGesture(t, g)
+
+	// Without LongHold, HOLD fires while the button is still down
+	timings.LongHold = 0
+	g, clock = newTestGestures(timings)
+	g.Down(TEST_BTN_X)
+	clock.Advance(timings.Hold)
+	if ev := nextGesture(t, g); ev.Kind != HOLD {
+		t.Errorf("Expected HOLD, got %s", ev)
+	}
+	g.Up(TEST_BTN_X)
+	clock.Advance(2 * timings.DoublePress)
+	noGesture(t, g)
+}
+
+func TestGestureShiftAndChord(t *testing.T) {
+	timings := testTimings
+	timings.Repeat = 0
+	g, clock := newTestGestures(timings)
+
+	// SHIFT is a modifier, its own release is swallowed
+	g.Down(BTN_SHIFT)
+	g.Down(TEST_BTN_Y)
+	g.Up(TEST_BTN_Y)
+	g.Up(BTN_SHIFT)
+	clock.Advance(2 * timings.DoublePress)
+	ev := nextGesture(t, g)
+	if ev.Pin != TEST_BTN_Y || ev.Kind != PRESS || !ev.Shift {
+		t.Errorf("Expected SHIFT+PRESS, got %s", ev)
+	}
+	noGesture(t, g)
+
+	// Any other held button makes a chord
+	g.Down(TEST_BTN_X)
+	g.Down(TEST_BTN_Y)
+	ev = nextGesture(t, g)
+	if ev.Pin != TEST_BTN_Y || ev.Kind != CHORD || ev.Value != TEST_BTN_X {
+		t.Errorf("Expected CHORD, got %s", ev)
+	}
+	g.Up(TEST_BTN_Y)
+	g.Up(TEST_BTN_X)
+	clock.Advance(2 * timings.LongHold)
+	noGesture(t, g)
+}
+
+func TestGestureDropped(t *testing.T) {
+	g, _ := newTestGestures(testTimings)
+	for i := 0; i < cap(g.events)+1; i++ {
+		g.Send(InputEvent{Pin: TEST_BTN_Y, Kind: PRESS})
+	}
+	if len(g.Events()) != cap(g.events) {
+		t.Errorf("Expected a full buffer, got %d", len(g.Events()))
+	}
+	g.Close()
+	// Closed, nothing is sent and nothing panics
+	g.Send(InputEvent{Pin: TEST_BTN_Y, Kind: PRESS})
+}
+
+func TestGestureDefaultBindings(t *testing.T) {
+	timings := DEFAULT_CONFIG.Gestures.Timings()
+	for _, test := range []struct {
+		pin      int
+		held     time.Duration
+		expected []string
+	}{
+		{TEST_BTN_Y, timings.Hold, []string{ACTION_ADD_FAVORITE}},
+		{TEST_BTN_Y, timings.LongHold + time.Second, []string{ACTION_ADD_FAVORITE}},
+		{TEST_BTN_X, timings.LongHold + time.Second, []string{ACTION_IDENTIFY}},
+		// Steps the volume while held, a press is still mute
+		{TEST_BTN_B, timings.Hold + timings.Repeat, []string{ACTION_VOLUME_UP, ACTION_VOLUME_UP}},
+		{TEST_BTN_B, 0, []string{ACTION_MUTE}},
+	} {
+		g, clock := newTestGestures(timings)
+		g.Down(test.pin)
+		clock.Advance(test.held)
+		g.Up(test.pin)
+		clock.Advance(2 * timings.DoublePress)
+		actions := []string{}
+		for len(g.Events()) > 0 {
+			if action, ok := DEFAULT_CONFIG.Binding(<-g.Events()); ok {
+				actions = append(actions, action)
+			}
+		}
+		if !reflect.DeepEqual(actions, test.expected) {
+			t.Errorf("Held %d for %s: expected %v, got %v", test.pin, test.held, test.expected, actions)
+		}
+	}
+}
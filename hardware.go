@@ -1,95 +1,131 @@
 package main
 
 import (
-	"os/exec"
 	"time"
 
 	"github.com/stianeikeland/go-rpio/v4"
 )
 
-const (
-	BTN_SHIFT       = 5
-	BTN_MUTED       = 6
-	BTN_PLAY_RANDOM = 16
-	BTN_PLAY_FAV    = 24
-)
+// Pirate Audio wiring, see `config.go` to change it. What the other
+// buttons do is up to the bindings.
+var BTN_SHIFT = 5
 
-var (
-	CMD_MUTE   = []byte("set Master 0%\n")
-	CMD_MAXVOL = []byte("set Master 100%\n")
-)
+// Buttons by the label printed on the HAT
+var BUTTONS = map[string]int{
+	"A": BTN_SHIFT,
+	"B": 6,
+	"X": 16,
+	"Y": 24,
+}
 
-var (
-	SHIFT_BUTTON = rpio.Pin(BTN_SHIFT)
-)
+// How often the buttons are sampled. A level has to hold for two samples
+// to count, which doubles as the debounce.
+var BUTTON_POLL = 20 * time.Millisecond
 
-func setup_shift_button() {
-	SHIFT_BUTTON.Input()
-	SHIFT_BUTTON.PullUp()
+// RpioInput reads the Pirate Audio buttons through go-rpio
+type RpioInput struct {
+	*GestureRecognizer
+	encoder *RotaryEncoder
+	dial    *Rotary8421Encoder
+	done    chan bool
 }
 
-func setup_mute_button() {
-	muted := false
-	muteChan := make(chan bool)
-	amixerCmd := exec.Command("amixer", "-s")
-	amixerInput, _ := amixerCmd.StdinPipe()
-	go amixerCmd.Start()
-	go amixerCmd.Wait()
-	amixerInput.Write(CMD_MAXVOL)
-	go on_press(BTN_MUTED, muteChan)
+func NewRpioInput() (*RpioInput, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, err
+	}
+	in := &RpioInput{
+		GestureRecognizer: NewGestureRecognizer(GESTURE_TIMINGS),
+		done:              make(chan bool),
+	}
+	pins := []int{}
+	for _, pin := range BUTTONS {
+		pins = append(pins, pin)
+	}
+	go in.poll(pins)
+	if ENCODER_ENABLED {
+		in.encoder = NewRotaryEncoder(ENC_A, ENC_B)
+		go in.on_turn()
+	}
+	if DIAL_ENABLED {
+		in.dial = New8421Encoder(DIAL_8, DIAL_4, DIAL_2, DIAL_1)
+		go in.on_dial()
+	}
+	return in, nil
+}
+
+func (in *RpioInput) Close() error {
+	close(in.done)
+	if in.encoder != nil {
+		in.encoder.Close()
+	}
+	if in.dial != nil {
+		in.dial.Close()
+	}
+	in.GestureRecognizer.Close()
+	return rpio.Close()
+}
+
+func (in *RpioInput) on_turn() {
 	for {
-		<-muteChan
-		if muted {
-			amixerInput.Write(CMD_MAXVOL)
-			muted = false
-		} else {
-			amixerInput.Write(CMD_MUTE)
-			muted = true
+		select {
+		case <-in.done:
+			return
+		case steps := <-in.encoder.Turn:
+			in.Send(InputEvent{Pin: ENC_A, Kind: TURN, Shift: in.shiftDownNow(), Value: steps})
 		}
 	}
 }
 
-func on_press(pinNumber int, c chan bool) {
-	pin := rpio.Pin(pinNumber)
-	pin.Input()
-	pin.PullUp()
-	pin.Detect(rpio.FallEdge)
-	defer pin.Detect(rpio.NoEdge)
-	last_call := time.Now()
+func (in *RpioInput) on_dial() {
 	for {
-		timeNow := time.Now()
-		if pin.EdgeDetected() && timeNow.Sub(last_call) > time.Millisecond*1000 {
-			last_call = timeNow
-			//log.Printf("Pin %d [PRESS] at %d\n", pinNumber, timeNow.UnixNano())
-			c <- true
+		select {
+		case <-in.done:
+			return
+		case position := <-in.dial.Changed:
+			in.Send(InputEvent{Pin: DIAL_8, Kind: DIAL, Value: position})
 		}
-		time.Sleep(time.Millisecond * 50)
 	}
 }
 
-func on_press_or_hold(pinNumber int, press chan bool, hold chan bool) {
-	pin := rpio.Pin(pinNumber)
-	pin.Input()
-	pin.PullUp()
-	pin.Detect(rpio.FallEdge)
-	defer pin.Detect(rpio.NoEdge)
-	last_call := time.Now()
+func (in *RpioInput) shiftDownNow() bool {
+	return rpio.Pin(BTN_SHIFT).Read() == rpio.Low
+}
+
+// poll feeds button edges to the gesture recognizer. Buttons pull the pin low.
+func (in *RpioInput) poll(pinNumbers []int) {
+	pins := make([]rpio.Pin, len(pinNumbers))
+	last := make([]rpio.State, len(pinNumbers))
+	stable := make([]rpio.State, len(pinNumbers))
+	for i, pinNumber := range pinNumbers {
+		pins[i] = rpio.Pin(pinNumber)
+		pins[i].Input()
+		pins[i].PullUp()
+		last[i] = rpio.High
+		stable[i] = rpio.High
+	}
+	ticker := time.NewTicker(BUTTON_POLL)
+	defer ticker.Stop()
 	for {
-		timeNow := time.Now()
-		if pin.EdgeDetected() && timeNow.Sub(last_call) > time.Millisecond*1000 {
-			last_call = timeNow
-			go func() {
-				time.Sleep(time.Millisecond * 500)
-				if pin.Read() == rpio.Low {
-					//log.Printf("Pin %d [HOLD] at %d\n", pinNumber, timeNow.UnixNano())
-					hold <- true
+		select {
+		case <-in.done:
+			return
+		case <-ticker.C:
+		}
+		for i, pin := range pins {
+			level := pin.Read()
+			if level == last[i] && level != stable[i] {
+				stable[i] = level
+				if level == rpio.Low {
+					//log.Printf("Pin %d [DOWN]\n", pinNumbers[i])
+					in.Down(pinNumbers[i])
 				} else {
-					//log.Printf("Pin %d [PRESS] at %d\n", pinNumber, timeNow.UnixNano())
-					press <- true
+					//log.Printf("Pin %d [UP]\n", pinNumbers[i])
+					in.Up(pinNumbers[i])
 				}
-			}()
+			}
+			last[i] = level
 		}
-		time.Sleep(time.Millisecond * 50)
 	}
 }
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	INPUT_RPIO     = "rpio"
+	INPUT_KEYBOARD = "keyboard"
+)
+
+const (
+	PRESS = iota
+	HOLD
+	TURN
+	DIAL
+	DOUBLE_PRESS
+	LONG_HOLD
+	CHORD
+	REPEAT
+)
+
+var INPUT_KIND_NAMES = map[int]string{
+	PRESS: "PRESS",
+	HOLD:  "HOLD",
+	TURN:  "TURN",
+	DIAL:  "DIAL",
+
+	DOUBLE_PRESS: "DOUBLE_PRESS",
+	LONG_HOLD:    "LONG_HOLD",
+	CHORD:        "CHORD",
+	REPEAT:       "REPEAT",
+}
+
+// InputEvent is a button gesture. `Pin` is the GPIO number of the button
+// (see `BTN_*`, `ENC_*`), so every backend speaks the same language as the HAT.
+type InputEvent struct {
+	Pin   int
+	Kind  int
+	Shift bool // SHIFT was held down when the event fired
+	// TURN: +1 clockwise, -1 counter-clockwise. DIAL: position 0-15.
+	// CHORD: the pin that was already held down.
+	Value int
+}
+
+func (ev InputEvent) String() string {
+	kind := INPUT_KIND_NAMES[ev.Kind]
+	switch ev.Kind {
+	case TURN:
+		kind = fmt.Sprintf("%s %+d", kind, ev.Value)
+	case DIAL:
+		kind = fmt.Sprintf("%s %d", kind, ev.Value)
+	case CHORD:
+		kind = fmt.Sprintf("%s with %d", kind, ev.Value)
+	}
+	if ev.Shift {
+		return fmt.Sprintf("SHIFT+%d %s", ev.Pin, kind)
+	}
+	return fmt.Sprintf("%d %s", ev.Pin, kind)
+}
+
+// InputSource emits button events. Backends that see raw button edges
+// feed them through a GestureRecognizer.
+type InputSource interface {
+	Events() <-chan InputEvent
+	Close() error
+}
+
+func NewInputSource(kind string) (InputSource, error) {
+	switch kind {
+	case INPUT_RPIO:
+		return NewRpioInput()
+	case INPUT_KEYBOARD:
+		return NewKeyboardInput(os.Stdin)
+	}
+	return nil, fmt.Errorf("unknown input `%s`", kind)
+}
+
+// ScriptedInput is driven by code, e.g. tests or a demo script
+type ScriptedInput struct {
+	events chan InputEvent
+}
+
+func NewScriptedInput() *ScriptedInput {
+	return &ScriptedInput{events: make(chan InputEvent, 16)}
+}
+
+func (in *ScriptedInput) Events() <-chan InputEvent {
+	return in.events
+}
+
+func (in *ScriptedInput) Send(ev InputEvent) {
+	in.events <- ev
+}
+
+func (in *ScriptedInput) Press(pin int) {
+	in.Send(InputEvent{Pin: pin, Kind: PRESS})
+}
+
+func (in *ScriptedInput) Hold(pin int) {
+	in.Send(InputEvent{Pin: pin, Kind: HOLD})
+}
+
+func (in *ScriptedInput) ShiftPress(pin int) {
+	in.Send(InputEvent{Pin: pin, Kind: PRESS, Shift: true})
+}
+
+func (in *ScriptedInput) ShiftHold(pin int) {
+	in.Send(InputEvent{Pin: pin, Kind: HOLD, Shift: true})
+}
+
+func (in *ScriptedInput) Turn(steps int, shift bool) {
+	in.Send(InputEvent{Pin: ENC_A, Kind: TURN, Shift: shift, Value: steps})
+}
+
+func (in *ScriptedInput) Dial(position int) {
+	in.Send(InputEvent{Pin: DIAL_8, Kind: DIAL, Value: position})
+}
+
+func (in *ScriptedInput) Close() error {
+	close(in.events)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectEvents(t *testing.T, events <-chan InputEvent, expected ...InputEvent) {
+	t.Helper()
+	for _, want := range expected {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Errorf("Expected %s, got %s", want, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s, got nothing", want)
+		}
+	}
+}
+
+func TestScriptedInput(t *testing.T) {
+	in := NewScriptedInput()
+	in.Press(TEST_BTN_X)
+	in.Hold(TEST_BTN_Y)
+	in.ShiftPress(TEST_BTN_X)
+	in.ShiftHold(TEST_BTN_Y)
+	in.Turn(-2, true)
+	in.Dial(7)
+	expectEvents(t, in.Events(),
+		InputEvent{Pin: TEST_BTN_X, Kind: PRESS},
+		InputEvent{Pin: TEST_BTN_Y, Kind: HOLD},
+		InputEvent{Pin: TEST_BTN_X, Kind: PRESS, Shift: true},
+		InputEvent{Pin: TEST_BTN_Y, Kind: HOLD, Shift: true},
+		InputEvent{Pin: ENC_A, Kind: TURN, Shift: true, Value: -2},
+		InputEvent{Pin: DIAL_8, Kind: DIAL, Value: 7},
+	)
+	in.Close()
+	if _, ok := <-in.Events(); ok {
+		t.Errorf("Expected the events closed")
+	}
+}
+
+// The scripted events go through the default bindings like the HAT's
+func TestScriptedInputBindings(t *testing.T) {
+	config := DEFAULT_CONFIG
+	in := NewScriptedInput()
+	in.Press(config.Buttons["X"])
+	in.ShiftPress(config.Buttons["X"])
+	in.Hold(config.Buttons["Y"])
+	in.ShiftHold(config.Buttons["Y"])
+	in.Close()
+	expected := []string{ACTION_PLAY_RANDOM, ACTION_PLAY_SIMILAR, ACTION_ADD_FAVORITE, ACTION_REMOVE_FAVORITE}
+	i := 0
+	for ev := range in.Events() {
+		action, ok := config.Binding(ev)
+		if !ok || action != expected[i] {
+			t.Errorf("%s: expected %s, got %q", ev, expected[i], action)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Errorf("Expected %d events, got %d", len(expected), i)
+	}
+}
+
+func TestKeyboardInput(t *testing.T) {
+	in, err := NewKeyboardInput(strings.NewReader("xYa]:ya;Y3q"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	expectEvents(t, in.Events(),
+		InputEvent{Pin: TEST_BTN_X, Kind: PRESS},
+		InputEvent{Pin: TEST_BTN_Y, Kind: HOLD},
+		InputEvent{Pin: ENC_A, Kind: TURN, Shift: true, Value: 1},
+		InputEvent{Pin: TEST_BTN_Y, Kind: DOUBLE_PRESS},
+		InputEvent{Pin: TEST_BTN_Y, Kind: LONG_HOLD, Shift: true},
+		InputEvent{Pin: DIAL_8, Kind: DIAL, Value: 3},
+	)
+	// `q` is no button, and the end of input closes the events
+	if ev, ok := <-in.Events(); ok {
+		t.Errorf("Unexpected %s", ev)
+	}
+}
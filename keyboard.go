@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Keys for running the radio from a terminal. Every button in BUTTONS is
+// the lower case of its label, lower case is a press and upper case is a
+// hold. The SHIFT key arms SHIFT for the next key. `:` makes the next
+// press a double press, `;` makes the next hold a long hold.
+func keyboard_buttons() map[rune]int {
+	keys := map[rune]int{}
+	for label, pin := range BUTTONS {
+		key := []rune(strings.ToLower(label))
+		if len(key) == 1 {
+			keys[key[0]] = pin
+		}
+	}
+	return keys
+}
+
+// `[` and `]` turn the rotary encoder
+var KEYBOARD_TURNS = map[rune]int{
+	'[': -1,
+	']': 1,
+}
+
+type KeyboardInput struct {
+	events chan InputEvent
+	file   *os.File
+	tty    string // `stty -g` state to restore on Close
+}
+
+func NewKeyboardInput(r io.Reader) (*KeyboardInput, error) {
+	in := &KeyboardInput{
+		events: make(chan InputEvent),
+	}
+	// Put the terminal in cbreak mode so keys arrive without Enter
+	if f, ok := r.(*os.File); ok {
+		state, err := stty(f, "-g")
+		if err == nil {
+			in.file = f
+			in.tty = strings.TrimSpace(state)
+			stty(f, "cbreak", "-echo")
+		}
+	}
+	fmt.Println("[INPUT] Keyboard: button label to press, upper case to hold, [/]=turn 0-9=dial :=double ;=long")
+	go in.read(r)
+	return in, nil
+}
+
+func (in *KeyboardInput) Events() <-chan InputEvent {
+	return in.events
+}
+
+func (in *KeyboardInput) Close() error {
+	if in.tty != "" {
+		_, err := stty(in.file, in.tty)
+		return err
+	}
+	return nil
+}
+
+func (in *KeyboardInput) read(r io.Reader) {
+	defer close(in.events)
+	reader := bufio.NewReader(r)
+	buttons := keyboard_buttons()
+	shift := false
+	double := false
+	long := false
+	for {
+		key, _, err := reader.ReadRune()
+		if err != nil {
+			return
+		}
+		if key == ':' {
+			double = true
+			continue
+		}
+		if key == ';' {
+			long = true
+			continue
+		}
+		if steps, ok := KEYBOARD_TURNS[key]; ok {
+			in.events <- InputEvent{Pin: ENC_A, Kind: TURN, Shift: shift, Value: steps}
+			shift = false
+			continue
+		}
+		// Digits set the preset dial
+		if key >= '0' && key <= '9' {
+			in.events <- InputEvent{Pin: DIAL_8, Kind: DIAL, Value: int(key - '0')}
+			continue
+		}
+		kind := PRESS
+		if key >= 'A' && key <= 'Z' {
+			kind = HOLD
+			key = key - 'A' + 'a'
+		}
+		pin, ok := buttons[key]
+		if !ok {
+			continue
+		}
+		if pin == BTN_SHIFT {
+			shift = !shift
+			fmt.Printf("[INPUT] SHIFT: %t\n", shift)
+			continue
+		}
+		if double && kind == PRESS {
+			kind = DOUBLE_PRESS
+		}
+		if long && kind == HOLD {
+			kind = LONG_HOLD
+		}
+		in.events <- InputEvent{Pin: pin, Kind: kind, Shift: shift}
+		shift = false
+		double = false
+		long = false
+	}
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
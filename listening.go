@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListeningStats is what was played, from `reliability.json`, with
+// whether it is a favorite of the active profile
+type ListeningStats struct {
+	UUID     string `json:"stationuuid"`
+	Favorite bool   `json:"favorite"`
+	StationStats
+}
+
+// Listening is every station that was ever tuned, most listened first
+func Listening(rel *Reliability, favorites []Station) []ListeningStats {
+	isFavorite := map[string]bool{}
+	for _, station := range favorites {
+		isFavorite[station.UUID] = true
+	}
+	all := []ListeningStats{}
+	for uuid, stats := range rel.All() {
+		all = append(all, ListeningStats{UUID: uuid, Favorite: isFavorite[uuid], StationStats: stats})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Listened != all[j].Listened {
+			return all[i].Listened > all[j].Listened
+		}
+		return all[i].UUID < all[j].UUID
+	})
+	return all
+}
+
+// ListeningWeight favours the favorites that are listened to the most,
+// without ever leaving the others out
+func ListeningWeight(stats StationStats) float64 {
+	return 1 + math.Log1p(stats.Listened.Hours())
+}
+
+// PrintListening writes a table for the terminal
+func PrintListening(w io.Writer, all []ListeningStats) {
+	fmt.Fprintf(w, "%-32s %3s %6s %9s %6s %6s  %s\n", "STATION", "FAV", "PLAYS", "LISTENED", "FAILS", "STALLS", "LAST PLAYED")
+	for _, stats := range all {
+		favorite := ""
+		if stats.Favorite {
+			favorite = "*"
+		}
+		if stats.Dead() {
+			favorite += "x"
+		}
+		lastPlayed := "never"
+		if !stats.LastPlayed.IsZero() {
+			lastPlayed = stats.LastPlayed.Format("2006-01-02 15:04")
+		}
+		name := []rune(stats.Name)
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		fmt.Fprintf(w, "%-32s %3s %6d %9s %6d %6d  %s\n",
+			string(name), favorite, stats.Starts, FormatListened(stats.Listened), stats.Failures, stats.Stalls, lastPlayed)
+	}
+}
+
+// ExportListening writes the stats to `path` as CSV, or JSON for `.json`
+func ExportListening(path string, all []ListeningStats) error {
+	buf := &bytes.Buffer{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		encoder := json.NewEncoder(buf)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(all); err != nil {
+			return err
+		}
+	case ".csv":
+		if err := writeListeningCSV(buf, all); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown format `%s`, use .csv or .json", filepath.Ext(path))
+	}
+	return WriteFileAtomic(path, buf.Bytes())
+}
+
+func writeListeningCSV(w io.Writer, all []ListeningStats) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"stationuuid", "name", "favorite", "plays", "listened_minutes", "failures", "stalls", "last_played", "dead"})
+	for _, stats := range all {
+		lastPlayed := ""
+		if !stats.LastPlayed.IsZero() {
+			lastPlayed = stats.LastPlayed.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			stats.UUID,
+			stats.Name,
+			strconv.FormatBool(stats.Favorite),
+			strconv.Itoa(stats.Starts),
+			strconv.Itoa(int(stats.Listened.Minutes())),
+			strconv.Itoa(stats.Failures),
+			strconv.Itoa(stats.Stalls),
+			lastPlayed,
+			strconv.FormatBool(stats.Dead()),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// FormatListened is short enough for the screen: 45M, 3H 20M, 120H
+func FormatListened(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	hours, minutes := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dM", minutes)
+	case hours >= 100 || minutes == 0:
+		return fmt.Sprintf("%dH", hours)
+	}
+	return fmt.Sprintf("%dH %dM", hours, minutes)
+}
+
+// DescribeListening is the card `show_stats` puts on screen
+func DescribeListening(station Station, stats StationStats) Info {
+	lines := []string{
+		station.Name,
+		fmt.Sprintf("%d PLAYS", stats.Starts),
+		FormatListened(stats.Listened),
+	}
+	if stats.Failures > 0 || stats.Stalls > 0 {
+		lines = append(lines, fmt.Sprintf("%d FAILS %d STALLS", stats.Failures, stats.Stalls))
+	}
+	return Info{Lines: lines}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListening(t *testing.T) {
+	rel := LoadReliability(filepath.Join(t.TempDir(), "reliability.json"))
+	rel.Started(testStationA, time.Second)
+	rel.Listened(testStationA, 90*time.Minute)
+	rel.Started(testStationB, time.Second)
+	rel.Listened(testStationB, 3*time.Hour)
+	rel.Stalled(testStationB)
+	rel.Failed(testStationC)
+
+	all := Listening(rel, []Station{testStationA, testStationC})
+	if len(all) != 3 || all[0].UUID != testStationB.UUID || all[2].UUID != testStationC.UUID {
+		t.Fatalf("Expected B, A, C, got %v", all)
+	}
+	if all[0].Favorite || !all[1].Favorite || !all[2].Favorite {
+		t.Errorf("Favorites not marked: %v", all)
+	}
+	if all[0].Starts != 1 || all[0].Stalls != 1 || all[0].LastPlayed.IsZero() {
+		t.Errorf("Unexpected stats for B: %+v", all[0].StationStats)
+	}
+	if !all[2].LastPlayed.IsZero() {
+		t.Errorf("C never played, yet it was last played %s", all[2].LastPlayed)
+	}
+
+	buf := &bytes.Buffer{}
+	PrintListening(buf, all)
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 || !strings.Contains(lines[2], "1H 30M") {
+		t.Errorf("Unexpected table:\n%s", buf.String())
+	}
+
+	dir := t.TempDir()
+	if err := ExportListening(filepath.Join(dir, "stats.txt"), all); err == nil {
+		t.Errorf("Expected an error for an unknown extension")
+	}
+	if err := ExportListening(filepath.Join(dir, "stats.csv"), all); err != nil {
+		t.Fatal(err)
+	}
+	fileData, _ := os.ReadFile(filepath.Join(dir, "stats.csv"))
+	records, err := csv.NewReader(bytes.NewReader(fileData)).ReadAll()
+	if err != nil || len(records) != 4 || records[1][1] != "B" || records[1][4] != "180" {
+		t.Errorf("Unexpected CSV %v: %v", records, err)
+	}
+	if err := ExportListening(filepath.Join(dir, "stats.json"), all); err != nil {
+		t.Fatal(err)
+	}
+	fileData, _ = os.ReadFile(filepath.Join(dir, "stats.json"))
+	exported := []ListeningStats{}
+	if err := json.Unmarshal(fileData, &exported); err != nil || len(exported) != 3 || exported[0].Listened != 3*time.Hour {
+		t.Errorf("Unexpected JSON %s: %v", fileData, err)
+	}
+}
+
+func TestFormatListened(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		30 * time.Second:              "0M",
+		45 * time.Minute:              "45M",
+		3*time.Hour + 20*time.Minute:  "3H 20M",
+		2 * time.Hour:                 "2H",
+		120*time.Hour + 5*time.Minute: "120H",
+	} {
+		if got := FormatListened(d); got != expected {
+			t.Errorf("%s: expected %s, got %s", d, expected, got)
+		}
+	}
+	if ListeningWeight(StationStats{}) != 1 || ListeningWeight(StationStats{Listened: 10 * time.Hour}) <= 1 {
+		t.Errorf("Listening more should weigh more")
+	}
+}
+
+func TestRadioShowStats(t *testing.T) {
+	f := newTestRadio(t)
+	f.Stats = LoadReliability(filepath.Join(t.TempDir(), "reliability.json"))
+	f.Stats.Listened(testStationA, 2*time.Hour)
+	f.ShowStats()
+	f.State()
+	if len(f.Display.ShowInfo) != 0 {
+		t.Errorf("Showed stats with nothing playing")
+	}
+	f.play(t, testStationA)
+	f.ShowStats()
+	info := <-f.Display.ShowInfo
+	if strings.Join(info.Lines, "|") != "A|1 PLAYS|2H" {
+		t.Errorf("Unexpected card %v", info.Lines)
+	}
+}
+
+func TestRadioListeningClock(t *testing.T) {
+	tick := LISTENING_TICK
+	LISTENING_TICK = 5 * time.Millisecond
+	defer func() { LISTENING_TICK = tick }()
+	f := newTestRadio(t)
+	f.Stats = LoadReliability(filepath.Join(t.TempDir(), "reliability.json"))
+	listened := func() time.Duration {
+		f.State()
+		return f.Stats.Get(testStationA.UUID).Listened
+	}
+
+	// Counted as it plays, not only when it ends
+	f.play(t, testStationA)
+	deadline := time.Now().Add(time.Second)
+	for listened() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Listening was not counted while playing")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Muted is not listening
+	f.SetMuted(true)
+	muted := listened()
+	time.Sleep(10 * LISTENING_TICK)
+	if l := listened(); l != muted {
+		t.Errorf("Counted %s while muted", l-muted)
+	}
+	f.SetMuted(false)
+	time.Sleep(2 * LISTENING_TICK)
+
+	// Neither is a stalled stream or a search
+	stalled := <-f.monitors
+	stalled()
+	f.expectState(t, RADIO_SEARCHING)
+	searching := listened()
+	if searching <= muted {
+		t.Errorf("Listening not counted after unmuting")
+	}
+	time.Sleep(10 * LISTENING_TICK)
+	if l := listened(); l != searching {
+		t.Errorf("Counted %s while searching", l-searching)
+	}
+}
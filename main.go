@@ -1,14 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
-
-	"github.com/stianeikeland/go-rpio/v4"
+	"time"
 )
 
 var HOME = getExecutableDirectory()
@@ -20,8 +21,33 @@ type Status struct {
 
 func main() {
 
-	// Required by fs.go
-	FAVORITES_FILE = filepath.Join(HOME, FAVORITES_FILE)
+	inputKind := flag.String("input", INPUT_RPIO, "button input: `rpio` or `keyboard`")
+	displayKind := flag.String("display", DISPLAY_PANEL, "display renderer: `panel`, `png` or `http`")
+	displayTarget := flag.String("display-target", "", "snapshot directory for `png`, listen address for `http`")
+	importFile := flag.String("import", "", "add the stations in an .m3u, .m3u8, .pls or .opml `file` to the favorites, then exit")
+	exportFile := flag.String("export", "", "write the favorites to an .m3u, .m3u8, .pls or .opml `file`, then exit")
+	showStats := flag.Bool("stats", false, "print what was listened to the most, then exit")
+	statsFile := flag.String("export-stats", "", "write the listening stats to a .csv or .json `file`, then exit")
+	flag.Parse()
+
+	// Pins and button bindings
+	CONFIG_FILE = filepath.Join(HOME, CONFIG_FILE)
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Printf("[CONFIG] %s\n", err)
+		os.Exit(1)
+	}
+	config.Apply()
+
+	// Required by fs.go, every profile has its own favorites and presets
+	STATE_FILE = filepath.Join(HOME, STATE_FILE)
+	state := NewStateStore(STATE_FILE)
+	profiles := NewProfiles(config.Profiles, state.Get().Profile)
+	profile := profiles.Active()
+	FAVORITES_FILE, PRESETS_FILE = profile.FavoritesFile(HOME), profile.PresetsFile(HOME)
+	if profile.Name != "" {
+		fmt.Printf("[PROFILE] %s\n", profile.Name)
+	}
 
 	// Required by display.go
 	STATUS_IMAGES_PATH = filepath.Join(HOME, STATUS_IMAGES_PATH)
@@ -31,21 +57,96 @@ func main() {
 	}
 
 	// Required by stations.go
-	err := get_languages_from_file()
+	err = get_languages_from_file()
 	if err != nil {
 		fmt.Printf("[STATIONS] Failed to get languages: %s\n", err)
 		os.Exit(1)
 	}
+	fileLanguages := Languages()
+	SetLanguages(profile.languages(fileLanguages))
 
-	// GPIO
-	if err := rpio.Open(); err != nil {
-		fmt.Printf("[GPIO] Failed to open GPIO: %s\n", err)
-		os.Exit(1)
+	// Mirrors come and go, ask DNS which are around. Boot doesn't wait for
+	// it, the built in list does until then.
+	go func() {
+		if err := RADIO_BROWSER.Discover(); err != nil {
+			fmt.Printf("[RADIO-BROWSER] %s\n", err)
+		}
+		fmt.Printf("[RADIO-BROWSER] Mirrors: %s\n", strings.Join(RADIO_BROWSER.Hosts(), ", "))
+	}()
+
+	// Favorites from and for other players
+	if *importFile != "" {
+		favorites, added, err := ImportFavorites(*importFile, getFavoriteStations(), RADIO_BROWSER)
+		if err == nil && added > 0 {
+			err = saveFavoriteStations(favorites)
+		}
+		if err != nil {
+			fmt.Printf("[IMPORT] %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[IMPORT] %d new favorites, %d in all\n", added, len(favorites))
+	}
+	if *exportFile != "" {
+		favorites := getFavoriteStations()
+		if err := ExportFavorites(*exportFile, favorites); err != nil {
+			fmt.Printf("[EXPORT] %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("[EXPORT] %d favorites to %s\n", len(favorites), *exportFile)
 	}
-	defer rpio.Close()
 
-	// Init display ST7789
-	display, err := NewDisplay()
+	// How well stations played before, to skip the ones that never start
+	RELIABILITY_FILE = filepath.Join(HOME, RELIABILITY_FILE)
+	RELIABILITY = LoadReliability(RELIABILITY_FILE)
+
+	// What we listen to, sent off for pruning the favorites
+	if *showStats || *statsFile != "" {
+		listening := Listening(RELIABILITY, getFavoriteStations())
+		if *showStats {
+			PrintListening(os.Stdout, listening)
+		}
+		if *statsFile != "" {
+			if err := ExportListening(*statsFile, listening); err != nil {
+				fmt.Printf("[STATS] %s\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("[STATS] %d stations to %s\n", len(listening), *statsFile)
+		}
+	}
+	if *importFile != "" || *exportFile != "" || *showStats || *statsFile != "" {
+		return
+	}
+
+	// Stations to pick from when the API is slow or down
+	CATALOG_FILE = filepath.Join(HOME, CATALOG_FILE)
+	CATALOG = LoadCatalog(CATALOG_FILE)
+	fmt.Printf("[CATALOG] %d stations\n", CATALOG.Len())
+	go CATALOG.Update(RADIO_BROWSER, profiles.Languages(fileLanguages))
+
+	// Buttons
+	input, err := NewInputSource(*inputKind)
+	if err != nil {
+		fmt.Printf("[INPUT] Failed to open %s: %s\n", *inputKind, err)
+		os.Exit(1)
+	}
+	defer input.Close()
+
+	// Init display, ST7789 unless told otherwise
+	if *displayTarget == "" {
+		switch *displayKind {
+		case DISPLAY_PNG:
+			*displayTarget = filepath.Join(HOME, "snapshots")
+		case DISPLAY_HTTP:
+			*displayTarget = ":8080"
+		}
+	}
+	renderer, err := NewRenderer(*displayKind, *displayTarget)
+	if err != nil {
+		fmt.Printf("[DISPLAY] Failed to init %s renderer: %s\n", *displayKind, err)
+		os.Exit(1)
+	}
+	defer renderer.Close()
+	display, err := NewDisplay(renderer)
 	if err != nil {
 		fmt.Printf("[DISPLAY] Failed to init display: %s\n", err)
 		os.Exit(1)
@@ -53,7 +154,7 @@ func main() {
 
 	// Check if ffmpeg is installed
 	ffmpegCmd := exec.Command("ffmpeg")
-	err = ffmpegCmd.Start()
+	err = CHILDREN.Start(ffmpegCmd)
 	if err != nil {
 		fmt.Printf("[FFMPEG] Failed to start: %s\n", err)
 		os.Exit(1)
@@ -97,181 +198,133 @@ func main() {
 
 	display.ShowStatus <- SPLASH
 
-	// `X` button
-	playRandom := make(chan bool)
-	identifySong := make(chan bool)
-	go on_press_or_hold(BTN_PLAY_RANDOM, playRandom, identifySong)
-
-	// `Y` button
-	playFav := make(chan bool)
-	saveFav := make(chan bool)
-	go on_press_or_hold(BTN_PLAY_FAV, playFav, saveFav)
-
-	// Channel to play station, sent from #playRandom and #playFav
-	playStation := make(chan Station)
-
-	// Channel receiving the result of #play_station
-	nextStationResult := make(chan StationStream)
-
-	identifySongResult := make(chan Track)
-
-	// The current station, set after receiving the result on nextStationResult
-	var currentStation = &StationStream{
-		Process: ffmpegCmd,
+	output, err := NewAudioOutput(config.Output.Kind, config.Output.Device, config.Output.Path)
+	if err != nil {
+		fmt.Printf("[AUDIO] Failed to open %s output: %s\n", config.Output.Kind, err)
+		os.Exit(1)
 	}
-
+	fmt.Printf("[AUDIO] Output: %s\n", output.Name())
 	audioSink := new(AudioSink)
-	audioSink.Init()
+	audioSink.Init(output)
 
-	favorite_stations := getFavoriteStations() // this is *never* empty
+	favorite_stations := getFavoriteStations() // may be empty if they were all removed
 
-	// Used to debounce button presses
-	isPlaying := false
-
-	// Volume control
-	go setup_mute_button()
-
-	// Shift button
-	setup_shift_button()
-
-	// Thread that handles button presses
-	go func() {
-		for {
-			select {
-			case <-playFav:
-				if isPlaying {
-					fmt.Println("[BUSY]")
-					continue
-				}
-				isPlaying = true
-				display.ShowStatus <- PLAYFAV
-				otherStations := []Station{}
-				for _, station := range favorite_stations {
-					if station.UUID != currentStation.UUID {
-						otherStations = append(otherStations, station)
-					}
-				}
-				if len(otherStations) > 1 {
-					playStation <- PickOne(otherStations)
-				} else {
-					playStation <- otherStations[0]
-				}
-			case <-playRandom:
-				if isPlaying {
-					fmt.Println("[BUSY]")
-					continue
-				}
-				isPlaying = true
-				display.ShowStatus <- SEARCH
-				fmt.Println("[STATIONS] Getting random station")
-				station, err := get_random_station(currentStation.Station)
-				if err != nil {
-					fmt.Printf("[STATIONS] %s\n", err)
-					isPlaying = false
-					display.ShowStatus <- ERROR
-					continue
-				}
-				playStation <- station
-			case <-saveFav:
-				if SHIFT_BUTTON.Read() == rpio.Low {
-					display.ShowStatus <- TRASH
-					remove_favorite_station(currentStation.Station, favorite_stations)
-					continue
-				}
-				display.ShowStatus <- ADDFAV
-				err := add_favorite_station(currentStation.Station, favorite_stations)
-				if err != nil {
-					fmt.Printf("Failed to save favorite station: %s\n", err)
-					continue
-				}
-				favorite_stations = append(favorite_stations, currentStation.Station)
-				fmt.Printf("[FAVORITES] [%d] Added: %s\n", len(favorite_stations), currentStation.Station.Name)
-			case <-identifySong:
-				if !IDENTIFY_ENABLED {
-					continue
-				}
-				go RecordAndIdentifySong(audioSink, identifySongResult)
-				display.ShowStatus <- IDENTIFY
-			}
+	radio := NewRadio(display, favorite_stations, getPresets())
+	radio.Tune = func(station Station, result chan StationStream) {
+		NewStationStream(station, audioSink, result)
+	}
+	if IDENTIFY_ENABLED {
+		radio.Identify = func(result chan Track) {
+			RecordAndIdentifySong(audioSink, result)
 		}
-	}()
+	}
+	if spotifyClient != nil {
+		radio.AddTrack = func(track Track) error {
+			return spotifyClient.AddTrackToLibrary(track.SpotifyID)
+		}
+	}
+	radio.Stats = RELIABILITY
+	go RELIABILITY.Run()
+	// Clicks and votes go back to radio-browser, it sorts by them
+	reporter := NewReporter(RADIO_BROWSER)
+	go reporter.Run()
+	radio.Reporter = reporter
+	radio.SaveStation = func(station Station) error {
+		return state.Update(func(saved *SavedState) { saved.Station = &station })
+	}
+	// Only the radio's goroutine saves favorites, so it is the one to move them
+	radio.LoadProfile = func(profile Profile) ([]Station, Presets) {
+		FAVORITES_FILE, PRESETS_FILE = profile.FavoritesFile(HOME), profile.PresetsFile(HOME)
+		return getFavoriteStations(), getPresets()
+	}
+	radio.updatePresets()
+
+	// Favorites follow their stations around radio-browser, dead ones are skipped
+	watch := NewFavoritesWatch(RELIABILITY, radio)
+	go watch.Run()
+
+	// Volume control, restored from the last run. Muted isn't listened to.
+	volume := NewVolume(display, state, radio.SetMuted)
+
+	actions := Actions{}
+	actions.Register(ACTION_PLAY_RANDOM, func(InputEvent) { radio.PlayRandom() })
+	actions.Register(ACTION_PLAY_SIMILAR, func(InputEvent) { radio.PlaySimilar() })
+	actions.Register(ACTION_PLAY_LIKE_FAVS, func(InputEvent) { radio.PlayLikeFavorites() })
+	actions.Register(ACTION_PLAY_NEARBY, func(InputEvent) { radio.PlayNearby() })
+	actions.Register(ACTION_PLAY_FAVORITE, func(InputEvent) { radio.PlayFavorite() })
+	actions.Register(ACTION_ADD_FAVORITE, func(InputEvent) { radio.AddFavorite() })
+	actions.Register(ACTION_REMOVE_FAVORITE, func(InputEvent) { radio.RemoveFavorite() })
+	actions.Register(ACTION_NEXT_FAVORITE, func(InputEvent) { radio.StepFavorite(1) })
+	actions.Register(ACTION_PREV_FAVORITE, func(InputEvent) { radio.StepFavorite(-1) })
+	actions.Register(ACTION_STEP_FAVORITE, func(ev InputEvent) { radio.StepFavorite(ev.Value) })
+	actions.Register(ACTION_MOVE_FAVORITE_UP, func(InputEvent) { radio.MoveFavorite(-1) })
+	actions.Register(ACTION_MOVE_FAVORITE_DOWN, func(InputEvent) { radio.MoveFavorite(1) })
+	actions.Register(ACTION_SWITCH_PROFILE, func(InputEvent) {
+		if len(profiles.List) < 2 {
+			display.ShowStatus <- HUH
+			return
+		}
+		from, to := profiles.Active(), profiles.Next()
+		SetLanguages(to.languages(fileLanguages))
+		if err := state.Update(func(saved *SavedState) { saved.Profile = to.Name }); err != nil {
+			fmt.Printf("[PROFILE] Failed to save: %s\n", err)
+		}
+		volume.SwitchProfile(from.Name, to.Name)
+		radio.SwitchProfile(to)
+	})
+	actions.Register(ACTION_SHOW_STATS, func(InputEvent) { radio.ShowStats() })
+	actions.Register(ACTION_PLAY_PRESET, func(ev InputEvent) { radio.PlayPreset(ev.Value) })
+	actions.Register(ACTION_IDENTIFY, func(InputEvent) { radio.IdentifySong() })
+	actions.Register(ACTION_MUTE, func(InputEvent) { volume.ToggleMute() })
+	actions.Register(ACTION_VOLUME, func(ev InputEvent) { volume.Step(ev.Value) })
+	actions.Register(ACTION_VOLUME_UP, func(InputEvent) { volume.Step(1) })
+	actions.Register(ACTION_VOLUME_DOWN, func(InputEvent) { volume.Step(-1) })
+	if err := actions.Check(config); err != nil {
+		fmt.Printf("[CONFIG] %s\n", err)
+		os.Exit(1)
+	}
 
 	go func() {
-		for {
-			select {
-			case station := <-playStation:
-				go NewStationStream(station, audioSink, currentStation, nextStationResult)
-			case station := <-nextStationResult:
-				if station.Started {
-					display.ShowStatus <- PLAYING
-					fmt.Printf("[ SET ]: %s\n", station.Name)
-					currentStation = &station
-					go station.Monitor(playRandom, display)
-				} else {
-					fmt.Println("[TIMEOUT] Station did not start")
-					// If a station is still playing, then let the user manually try again
-					if isAlive(currentStation.Process) {
-						display.ShowStatus <- ERROR
-					} else {
-						// If nothing is playing, then try again automatically. Note, if stations
-						// keep failing (in the case of the network being down), this branch
-						// will loop indefinitely
-						playRandom <- true
-					}
-				}
-				isPlaying = false
-			case track := <-identifySongResult:
-				if track.OK {
-					if spotifyClient == nil || track.SpotifyID == "" {
-						escaped := url.QueryEscape(track.Title + " " + track.Artist)
-						yt_seatrch_url := YOUTUBE_SEARCH + escaped
-						display.ShowQR <- QR{yt_seatrch_url, 60, PLAYING}
-						continue
-					}
-					err := spotifyClient.AddTrackToLibrary(track.SpotifyID)
-					if err != nil {
-						display.ShowStatus <- ERROR
-						continue
-					}
-					fmt.Printf("[SPOTIFY] Added: %s - %s\n", track.Title, track.Artist)
-					display.ShowStatus <- ADDFAV
-				} else {
-					display.ShowStatus <- HUH
-				}
-			}
+		for ev := range input.Events() {
+			actions.Dispatch(config, ev)
 		}
 	}()
 
-	// playStation <- Station{
+	// radio.Start(Station{
 	// 	Name: "Silent Test Station",
 	// 	URL:  "https://smack.s3.ap-southeast-1.amazonaws.com/pie_silence.mp3",
-	// }
-	playStation <- PickOne(favorite_stations)
-
-	select {}
-
-}
-
-func add_favorite_station(newStation Station, currentStations []Station) error {
-	// Check if station is already in favorites
-	for _, station := range currentStations {
-		if station.Name == newStation.Name {
-			return nil
+	// })
+	// Come back on the station that was playing before a restart
+	if saved := state.Get().Station; saved != nil && saved.URL != "" {
+		fmt.Printf("[RESUME] %s\n", saved.Name)
+		radio.Start(*saved)
+	} else {
+		boot_stations := favorite_stations
+		if len(boot_stations) == 0 {
+			boot_stations = DEFAULT_FAVORITES
 		}
+		radio.Start(PickOne(boot_stations))
 	}
-	currentStations = append(currentStations, newStation)
-	return saveFavoriteStations(currentStations)
-}
 
-func remove_favorite_station(removeThisStation Station, currentStations []Station) error {
-	fmt.Println("Removing station: ", removeThisStation.Name)
-	saveStations := []Station{}
-	for _, station := range currentStations {
-		if station.Name != removeThisStation.Name {
-			saveStations = append(saveStations, station)
-		}
-	}
-	return saveFavoriteStations(saveStations)
+	go radio.Run()
+
+	// systemd stops us with SIGTERM, a terminal with SIGINT
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	fmt.Printf("[SHUTDOWN] %s\n", sig)
+	signal.Stop(signals)
+
+	radio.Stop()
+	reporter.Close()
+	watch.Close()
+	RELIABILITY.Close()
+	volume.Close()
+	audioSink.Close()
+	CHILDREN.Stop(2 * time.Second)
+	display.Blank()
+
+	// The deferred closes release the input and the display
 }
 
 func isAlive(cmd *exec.Cmd) bool {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Decoded audio is always 16 bit little endian PCM at this rate and channel count
+const (
+	SAMPLE_RATE     = 44100
+	CHANNELS        = 2
+	BITS_PER_SAMPLE = 16
+)
+
+const (
+	OUTPUT_APLAY    = "aplay"
+	OUTPUT_PIPEWIRE = "pipewire"
+	OUTPUT_PULSE    = "pulse"
+	OUTPUT_WAV      = "wav"
+	OUTPUT_NULL     = "null"
+)
+
+// AudioOutput is where AudioSink sends the PCM it is given
+type AudioOutput interface {
+	io.WriteCloser
+	Name() string
+}
+
+// NewAudioOutput returns the output `kind`. `device` is passed to aplay,
+// pw-cat or pacat when set; `path` is the file the wav output writes.
+// Player processes are restarted by a Supervisor if they die.
+func NewAudioOutput(kind string, device string, path string) (AudioOutput, error) {
+	var command string
+	var args []string
+	switch kind {
+	case OUTPUT_APLAY:
+		command = "aplay"
+		args = []string{"-q", "-t", "raw", "-f", "S16_LE", "-r", fmt.Sprint(SAMPLE_RATE), "-c", fmt.Sprint(CHANNELS)}
+		if device != "" {
+			args = append(args, "-D", device)
+		}
+		args = append(args, "-")
+	case OUTPUT_PIPEWIRE:
+		command = "pw-cat"
+		args = []string{"--playback", "--format", "s16", "--rate", fmt.Sprint(SAMPLE_RATE), "--channels", fmt.Sprint(CHANNELS)}
+		if device != "" {
+			args = append(args, "--target", device)
+		}
+		args = append(args, "-")
+	case OUTPUT_PULSE:
+		command = "pacat"
+		args = []string{"--playback", "--raw", "--format=s16le", fmt.Sprintf("--rate=%d", SAMPLE_RATE), fmt.Sprintf("--channels=%d", CHANNELS)}
+		if device != "" {
+			args = append(args, "--device="+device)
+		}
+	case OUTPUT_WAV:
+		return NewWavOutput(path)
+	case OUTPUT_NULL:
+		return NullOutput{}, nil
+	default:
+		return nil, fmt.Errorf("unknown audio output `%s`", kind)
+	}
+	return NewSupervisor(command, func() (io.WriteCloser, error) {
+		return NewCommandOutput(kind, command, args...)
+	})
+}
+
+// CommandOutput pipes audio into a player process
+type CommandOutput struct {
+	name string
+	Cmd  *exec.Cmd
+	in   io.WriteCloser
+}
+
+func NewCommandOutput(name string, command string, args ...string) (*CommandOutput, error) {
+	cmd := exec.Command(command, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := CHILDREN.Start(cmd); err != nil {
+		return nil, fmt.Errorf("%s: %v", command, err)
+	}
+	return &CommandOutput{name, cmd, stdin}, nil
+}
+
+func (out *CommandOutput) Name() string {
+	return out.name
+}
+
+func (out *CommandOutput) Write(b []byte) (int, error) {
+	return out.in.Write(b)
+}
+
+func (out *CommandOutput) Close() error {
+	out.in.Close()
+	return out.Cmd.Process.Kill()
+}
+
+// WavOutput records everything played into a wav file. The sizes in the
+// header are filled in on Close.
+type WavOutput struct {
+	mu      sync.Mutex
+	file    *os.File
+	written uint32
+}
+
+func NewWavOutput(path string) (*WavOutput, error) {
+	if path == "" {
+		return nil, fmt.Errorf("wav output needs a path")
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	out := &WavOutput{file: file}
+	if err := out.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (out *WavOutput) Name() string {
+	return OUTPUT_WAV
+}
+
+func (out *WavOutput) writeHeader() error {
+	blockAlign := CHANNELS * BITS_PER_SAMPLE / 8
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + out.written),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),
+		uint16(1), // PCM
+		uint16(CHANNELS),
+		uint32(SAMPLE_RATE),
+		uint32(SAMPLE_RATE * blockAlign),
+		uint16(blockAlign),
+		uint16(BITS_PER_SAMPLE),
+		[4]byte{'d', 'a', 't', 'a'},
+		out.written,
+	}
+	for _, field := range header {
+		if err := binary.Write(out.file, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (out *WavOutput) Write(b []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	n, err := out.file.Write(b)
+	out.written += uint32(n)
+	return n, err
+}
+
+func (out *WavOutput) Close() error {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	if _, err := out.file.Seek(0, io.SeekStart); err != nil {
+		out.file.Close()
+		return err
+	}
+	if err := out.writeHeader(); err != nil {
+		out.file.Close()
+		return err
+	}
+	return out.file.Close()
+}
+
+// NullOutput throws the audio away, the stream still has to be decoded
+// for the monitor and song identification to work
+type NullOutput struct{}
+
+func (NullOutput) Name() string {
+	return OUTPUT_NULL
+}
+
+func (NullOutput) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (NullOutput) Close() error {
+	return nil
+}
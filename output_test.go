@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWavOutput(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "out.wav")
+	out, err := NewAudioOutput(OUTPUT_WAV, "", fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm := bytes.Repeat([]byte{1, 2, 3, 4}, 1000)
+	out.Write(pcm[:1000])
+	out.Write(pcm[1000:])
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 44+len(pcm) {
+		t.Fatalf("Expected %d bytes, got %d", 44+len(pcm), len(b))
+	}
+	if string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" || string(b[36:40]) != "data" {
+		t.Errorf("Bad header: %q", b[:44])
+	}
+	if size := binary.LittleEndian.Uint32(b[40:44]); size != uint32(len(pcm)) {
+		t.Errorf("Data size is %d, expected %d", size, len(pcm))
+	}
+	if rate := binary.LittleEndian.Uint32(b[24:28]); rate != SAMPLE_RATE {
+		t.Errorf("Sample rate is %d", rate)
+	}
+	if !bytes.Equal(b[44:], pcm) {
+		t.Error("PCM was not written as is")
+	}
+}
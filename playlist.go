@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	PLAYLIST_TIMEOUT = 10 * time.Second
+	// Playlists pointing at playlists are followed this deep
+	PLAYLIST_MAX_DEPTH = 3
+	// Nobody needs a bigger playlist than this, a stream would be endless
+	PLAYLIST_MAX_SIZE = int64(1 << 20)
+
+	PLAYLIST_CLIENT = &http.Client{Timeout: PLAYLIST_TIMEOUT}
+)
+
+const (
+	PLAYLIST_NONE = ""
+	PLAYLIST_PLS  = "pls"
+	PLAYLIST_M3U  = "m3u"
+	PLAYLIST_ASX  = "asx"
+	PLAYLIST_XSPF = "xspf"
+)
+
+var PLAYLIST_CONTENT_TYPES = map[string]string{
+	"audio/x-scpls":                 PLAYLIST_PLS,
+	"audio/scpls":                   PLAYLIST_PLS,
+	"audio/x-mpegurl":               PLAYLIST_M3U,
+	"audio/mpegurl":                 PLAYLIST_M3U,
+	"video/x-ms-asf":                PLAYLIST_ASX,
+	"video/x-ms-asx":                PLAYLIST_ASX,
+	"audio/x-ms-wax":                PLAYLIST_ASX,
+	"application/xspf+xml":          PLAYLIST_XSPF,
+	"application/vnd.ms-asf":        PLAYLIST_ASX,
+	"application/x-mpegurl":         PLAYLIST_M3U, // often HLS, ParsePlaylist tells them apart
+	"application/x-scpls":           PLAYLIST_PLS,
+	"application/pls+xml":           PLAYLIST_PLS,
+	"application/x-ms-asx":          PLAYLIST_ASX,
+	"application/x-winamp-playlist": PLAYLIST_M3U,
+}
+
+var PLAYLIST_EXTENSIONS = map[string]string{
+	".pls":  PLAYLIST_PLS,
+	".m3u":  PLAYLIST_M3U,
+	".asx":  PLAYLIST_ASX,
+	".wax":  PLAYLIST_ASX,
+	".xspf": PLAYLIST_XSPF,
+}
+
+// STREAM_EXTENSIONS are surely not playlists, HLS included
+var STREAM_EXTENSIONS = map[string]bool{
+	".mp3":  true,
+	".aac":  true,
+	".aacp": true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".flac": true,
+	".m4a":  true,
+	".m3u8": true,
+}
+
+// ResolveStreamURLs turns a station URL into the stream URLs ffmpeg
+// should try, in order. A URL with a playlist extension is fetched. For
+// any other URL, like `/listen` or `/stream.php`, only the headers are
+// asked for, as a stream is not worth connecting to twice, and it is
+// fetched if the Content-Type says playlist. Streams and HLS are left to
+// ffmpeg. If a playlist can't be fetched its URL is returned, ffmpeg may
+// still have better luck. There is always at least one URL.
+func ResolveStreamURLs(streamURL string) []string {
+	return resolveStreamURLs(streamURL, 0)
+}
+
+func resolveStreamURLs(streamURL string, depth int) []string {
+	u, err := url.Parse(streamURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return []string{streamURL}
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if STREAM_EXTENSIONS[ext] || depth > PLAYLIST_MAX_DEPTH {
+		return []string{streamURL}
+	}
+	if PLAYLIST_EXTENSIONS[ext] == PLAYLIST_NONE {
+		playlistURL, ok := headPlaylist(streamURL)
+		if !ok {
+			return []string{streamURL}
+		}
+		streamURL = playlistURL
+	}
+
+	req, err := http.NewRequest(http.MethodGet, streamURL, nil)
+	if err != nil {
+		return []string{streamURL}
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	res, err := PLAYLIST_CLIENT.Do(req)
+	if err != nil {
+		fmt.Printf("[PLAYLIST] %s\n", err)
+		return []string{streamURL}
+	}
+	defer res.Body.Close()
+	final := res.Request.URL.String()
+	if res.StatusCode != http.StatusOK {
+		fmt.Printf("[PLAYLIST] [%s] %s\n", final, res.Status)
+		return []string{final}
+	}
+
+	// The server knows better than the extension, it may redirect to a stream
+	kind := playlistKind(res.Request.URL, res.Header.Get("Content-Type"))
+	if kind == PLAYLIST_NONE {
+		return []string{final}
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, PLAYLIST_MAX_SIZE))
+	if err != nil {
+		return []string{final}
+	}
+	entries, err := ParsePlaylist(kind, body)
+	if err == nil && entries == nil {
+		// HLS
+		return []string{final}
+	}
+	if err != nil || len(entries) == 0 {
+		fmt.Printf("[PLAYLIST] [%s] Nothing playable: %v\n", final, err)
+		return []string{final}
+	}
+
+	urls := []string{}
+	for _, entry := range entries {
+		ref, err := res.Request.URL.Parse(entry)
+		if err != nil {
+			continue
+		}
+		urls = append(urls, resolveStreamURLs(ref.String(), depth+1)...)
+	}
+	if urls = dedupe(urls); len(urls) == 0 {
+		return []string{final}
+	}
+	return urls
+}
+
+// headPlaylist asks for the headers of `streamURL`, and returns where it
+// redirects to if that is a playlist. A server that can't answer a HEAD
+// is taken for a stream.
+func headPlaylist(streamURL string) (string, bool) {
+	req, err := http.NewRequest(http.MethodHead, streamURL, nil)
+	if err != nil {
+		return "", false
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	res, err := PLAYLIST_CLIENT.Do(req)
+	if err != nil {
+		return "", false
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || playlistKind(res.Request.URL, res.Header.Get("Content-Type")) == PLAYLIST_NONE {
+		return "", false
+	}
+	return res.Request.URL.String(), true
+}
+
+func playlistKind(u *url.URL, contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if kind, ok := PLAYLIST_CONTENT_TYPES[strings.ToLower(mediaType)]; ok {
+			return kind
+		}
+	}
+	return PLAYLIST_EXTENSIONS[strings.ToLower(path.Ext(u.Path))]
+}
+
+// ParsePlaylist returns the entries of a playlist, as written. An m3u
+// that turns out to be HLS has no entries for us, ffmpeg plays it.
+func ParsePlaylist(kind string, body []byte) ([]string, error) {
+	switch kind {
+	case PLAYLIST_PLS:
+		return parsePLS(body), nil
+	case PLAYLIST_M3U:
+		if bytes.Contains(body, []byte("#EXT-X-")) {
+			return nil, nil
+		}
+		return parseM3U(body), nil
+	case PLAYLIST_ASX:
+		return parseASX(body), nil
+	case PLAYLIST_XSPF:
+		return parseXSPF(body)
+	}
+	return nil, fmt.Errorf("Unknown playlist `%s`", kind)
+}
+
+// [playlist]
+// File1=http://...
+func parsePLS(body []byte) []string {
+	entries := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && strings.HasPrefix(strings.ToLower(key), "file") {
+			entries = append(entries, strings.TrimSpace(value))
+		}
+	}
+	return entries
+}
+
+// #EXTM3U
+// #EXTINF:-1,Station
+// http://...
+func parseM3U(body []byte) []string {
+	entries := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// ASX is rarely valid XML, so the refs are picked out by hand
+//
+//	<asx version="3.0"><entry><ref href="http://..." /></entry></asx>
+var asxRef = regexp.MustCompile(`(?i)<ref\s+href\s*=\s*["']([^"']+)["']`)
+
+func parseASX(body []byte) []string {
+	entries := []string{}
+	for _, match := range asxRef.FindAllSubmatch(body, -1) {
+		entries = append(entries, strings.TrimSpace(string(match[1])))
+	}
+	return entries
+}
+
+// <playlist><trackList><track><location>http://...</location></track></trackList></playlist>
+func parseXSPF(body []byte) ([]string, error) {
+	var playlist struct {
+		Tracks []struct {
+			Locations []string `xml:"location"`
+		} `xml:"trackList>track"`
+	}
+	if err := xml.Unmarshal(body, &playlist); err != nil {
+		return nil, err
+	}
+	entries := []string{}
+	for _, track := range playlist.Tracks {
+		for _, location := range track.Locations {
+			entries = append(entries, strings.TrimSpace(location))
+		}
+	}
+	return entries, nil
+}
+
+func dedupe(items []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, item := range items {
+		if item != "" && !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestParsePlaylist(t *testing.T) {
+	tests := []struct {
+		kind     string
+		body     string
+		expected []string
+	}{
+		{PLAYLIST_PLS, "[playlist]\nNumberOfEntries=2\nFile1=http://a/1\nTitle1=A\nfile2 = http://a/2\n", []string{"http://a/1", "http://a/2"}},
+		{PLAYLIST_M3U, "\ufeff#EXTM3U\n#EXTINF:-1,A\nhttp://a/1\n\nhttp://a/2\r\n", []string{"http://a/1", "http://a/2"}},
+		{PLAYLIST_M3U, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=96000\nchunks.m3u8\n", nil},
+		{PLAYLIST_ASX, `<ASX version="3.0"><Entry><REF HREF="http://a/1"/></Entry><entry><ref href='http://a/2' /></entry></ASX>`, []string{"http://a/1", "http://a/2"}},
+		{PLAYLIST_XSPF, `<?xml version="1.0"?><playlist version="1" xmlns="http://xspf.org/ns/0/"><trackList><track><location>http://a/1</location></track></trackList></playlist>`, []string{"http://a/1"}},
+	}
+	for _, test := range tests {
+		entries, err := ParsePlaylist(test.kind, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %s", test.kind, err)
+		}
+		if !reflect.DeepEqual(entries, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.kind, test.expected, entries)
+		}
+	}
+}
+
+func TestResolveStreamURLs(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/listen.pls", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/station.pls", http.StatusFound)
+	})
+	mux.HandleFunc("/station.pls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-scpls")
+		w.Write([]byte("[playlist]\nFile1=/more.m3u\nFile2=/stream2\n"))
+	})
+	mux.HandleFunc("/more.m3u", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-mpegurl")
+		w.Write([]byte("/stream1\n/stream2\n"))
+	})
+	mux.HandleFunc("/live.m3u", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/stream1", http.StatusFound)
+	})
+	mux.HandleFunc("/listen", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/stream.php", http.StatusFound)
+	})
+	mux.HandleFunc("/stream.php", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-scpls")
+		w.Write([]byte("[playlist]\nFile1=/stream1\n"))
+	})
+	mux.HandleFunc("/stream1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("ID3"))
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	expectRequests := func(expected map[string]int) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(requests, expected) {
+			t.Errorf("Expected requests %v, got %v", expected, requests)
+		}
+		requests = map[string]int{}
+	}
+
+	urls := ResolveStreamURLs(server.URL + "/listen.pls")
+	expected := []string{server.URL + "/stream1", server.URL + "/stream2"}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected %v, got %v", expected, urls)
+	}
+	// The streams themselves are ffmpeg's to connect to, we only ask for their headers
+	expectRequests(map[string]int{
+		"GET /listen.pls": 1, "GET /station.pls": 1, "GET /more.m3u": 1,
+		"HEAD /stream1": 1, "HEAD /stream2": 2,
+	})
+
+	// A playlist that is a stream after all
+	if urls := ResolveStreamURLs(server.URL + "/live.m3u"); !reflect.DeepEqual(urls, []string{server.URL + "/stream1"}) {
+		t.Errorf("Expected the stream it redirects to, got %v", urls)
+	}
+	expectRequests(map[string]int{"GET /live.m3u": 1, "GET /stream1": 1})
+
+	// Only the Content-Type says playlist, behind a redirect
+	if urls := ResolveStreamURLs(server.URL + "/listen?type=pls"); !reflect.DeepEqual(urls, []string{server.URL + "/stream1"}) {
+		t.Errorf("Expected the stream in the playlist, got %v", urls)
+	}
+	expectRequests(map[string]int{
+		"HEAD /listen": 1, "HEAD /stream.php": 1, "GET /stream.php": 1, "HEAD /stream1": 1,
+	})
+
+	// A stream without an extension is left alone after a look at its headers
+	if urls := ResolveStreamURLs(server.URL + "/stream1"); !reflect.DeepEqual(urls, []string{server.URL + "/stream1"}) {
+		t.Errorf("Expected the stream untouched, got %v", urls)
+	}
+	expectRequests(map[string]int{"HEAD /stream1": 1})
+
+	// Streams and HLS by their extension are left alone, without a request
+	for _, streamURL := range []string{server.URL + "/live.mp3", server.URL + "/live/index.m3u8"} {
+		if urls := ResolveStreamURLs(streamURL); !reflect.DeepEqual(urls, []string{streamURL}) {
+			t.Errorf("Expected %s untouched, got %v", streamURL, urls)
+		}
+	}
+	expectRequests(map[string]int{})
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// Only Linux can tie a child's life to ours, elsewhere Children.Stop has to do
+func setParentDeathSignal(cmd *exec.Cmd) {}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Profile is one listener's favorites, presets, languages and volume. The
+// favorites and presets live next to the shared ones, in
+// `favstations.<name>.json` and `presets.<name>.json`.
+type Profile struct {
+	Name      string   `json:"name"`
+	Languages []string `json:"languages,omitempty"` // `languages.txt` when empty
+}
+
+// DEFAULT_PROFILE is the only one without profiles in `config.json`, it
+// uses the plain `favstations.json` and `presets.json`
+var DEFAULT_PROFILE = Profile{}
+
+// Slug is the name as it goes in file names
+func (profile Profile) Slug() string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, profile.Name), "-")
+}
+
+// FavoritesFile is where the profile keeps its favorites, `home` joined
+func (profile Profile) FavoritesFile(home string) string {
+	return profileFile(home, "favstations", profile.Slug())
+}
+
+func (profile Profile) PresetsFile(home string) string {
+	return profileFile(home, "presets", profile.Slug())
+}
+
+func profileFile(home string, base string, slug string) string {
+	if slug == "" {
+		return filepath.Join(home, base+".json")
+	}
+	return filepath.Join(home, base+"."+slug+".json")
+}
+
+// Profiles cycles through the profiles from `config.json`
+type Profiles struct {
+	List   []Profile
+	active int
+}
+
+// NewProfiles starts on the profile called `active`, or the first one
+func NewProfiles(list []Profile, active string) *Profiles {
+	if len(list) == 0 {
+		list = []Profile{DEFAULT_PROFILE}
+	}
+	profiles := &Profiles{List: list}
+	for i, profile := range list {
+		if profile.Name == active {
+			profiles.active = i
+		}
+	}
+	return profiles
+}
+
+func (profiles *Profiles) Active() Profile {
+	return profiles.List[profiles.active]
+}
+
+// Next makes the next profile active, wrapping around
+func (profiles *Profiles) Next() Profile {
+	profiles.active = (profiles.active + 1) % len(profiles.List)
+	return profiles.Active()
+}
+
+// Languages of every profile, for the catalog to cover them all
+func (profiles *Profiles) Languages(fallback []string) []string {
+	languages := []string{}
+	for _, profile := range profiles.List {
+		languages = append(languages, profile.languages(fallback)...)
+	}
+	return dedupe(languages)
+}
+
+// languages is what the profile searches in, `fallback` when it doesn't say
+func (profile Profile) languages(fallback []string) []string {
+	if len(profile.Languages) == 0 {
+		return fallback
+	}
+	return profile.Languages
+}
+
+func checkProfiles(profiles []Profile) error {
+	slugs := map[string]string{}
+	for _, profile := range profiles {
+		slug := profile.Slug()
+		if slug == "" {
+			return fmt.Errorf("profile `%s` needs a name with letters or digits", profile.Name)
+		}
+		if other, ok := slugs[slug]; ok {
+			return fmt.Errorf("profiles `%s` and `%s` would share their files", other, profile.Name)
+		}
+		slugs[slug] = profile.Name
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	home := "/home/pi/whatradio"
+	if file := DEFAULT_PROFILE.FavoritesFile(home); file != filepath.Join(home, "favstations.json") {
+		t.Errorf("The default profile moved its favorites to %s", file)
+	}
+	anna := Profile{Name: "Anna Ölund", Languages: []string{"swedish"}}
+	if file := anna.PresetsFile(home); file != filepath.Join(home, "presets.anna-ölund.json") {
+		t.Errorf("Unexpected presets file %s", file)
+	}
+
+	kids := Profile{Name: "Kids"}
+	profiles := NewProfiles([]Profile{anna, kids}, "Kids")
+	if profiles.Active().Name != "Kids" {
+		t.Errorf("Expected to start on Kids, got %s", profiles.Active().Name)
+	}
+	if next := profiles.Next(); next.Name != anna.Name {
+		t.Errorf("Expected to wrap around to Anna, got %s", next.Name)
+	}
+	if languages := strings.Join(profiles.Languages([]string{"english", "swedish"}), ","); languages != "swedish,english" {
+		t.Errorf("Unexpected languages %s", languages)
+	}
+	if profiles := NewProfiles(nil, "Gone"); profiles.Active().Name != "" || profiles.Next().Name != "" {
+		t.Errorf("Expected only the default profile")
+	}
+
+	if err := checkProfiles([]Profile{anna, kids}); err != nil {
+		t.Error(err)
+	}
+	if err := checkProfiles([]Profile{kids, {Name: "kids!"}}); err == nil {
+		t.Errorf("Expected an error for profiles sharing files")
+	}
+	if err := checkProfiles([]Profile{{Name: "??"}}); err == nil {
+		t.Errorf("Expected an error for a profile without a name")
+	}
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type RadioState int
+
+const (
+	RADIO_BOOTING RadioState = iota
+	RADIO_SEARCHING
+	RADIO_TUNING
+	RADIO_PLAYING
+	RADIO_IDENTIFYING
+	RADIO_ERROR
+)
+
+var RADIO_STATE_NAMES = map[RadioState]string{
+	RADIO_BOOTING:     "BOOTING",
+	RADIO_SEARCHING:   "SEARCHING",
+	RADIO_TUNING:      "TUNING",
+	RADIO_PLAYING:     "PLAYING",
+	RADIO_IDENTIFYING: "IDENTIFYING",
+	RADIO_ERROR:       "ERROR",
+}
+
+func (s RadioState) String() string {
+	return RADIO_STATE_NAMES[s]
+}
+
+// How long the radio waits in a busy state before giving up on it. Tuning
+// has its own 30 second timeout in NewStationStream, this is the backstop.
+var RADIO_TIMEOUTS = map[RadioState]time.Duration{
+	RADIO_SEARCHING:   45 * time.Second,
+	RADIO_TUNING:      45 * time.Second,
+	RADIO_IDENTIFYING: 60 * time.Second,
+}
+
+// How often the time listened to the current station is added up, a power
+// cut loses no more than this
+var LISTENING_TICK = time.Minute
+
+// Radio is the state machine behind the buttons. A single goroutine, `Run`,
+// owns all the state; everything else talks to it through `do`.
+//
+//	BOOTING     -> TUNING                           Start
+//	TUNING      -> TUNING                           boot station failed, try a favorite
+//	PLAYING     -> SEARCHING | TUNING | IDENTIFYING buttons
+//	ERROR       -> SEARCHING | TUNING               buttons
+//	SEARCHING   -> TUNING | PLAYING | ERROR         search result, timeout
+//	TUNING      -> PLAYING | SEARCHING | ERROR      tune result, timeout
+//	PLAYING     -> SEARCHING                        stream stalled
+//	IDENTIFYING -> PLAYING                          identify result, timeout
+type Radio struct {
+	Display   *Display
+	Favorites []Station
+	Presets   Presets
+
+	// Dependencies, swapped out in tests
+	Search         func(current Station) (Station, error)
+	Similar        func(current Station, favorites []Station) (Station, error)
+	LikeFavorites  func(current Station, favorites []Station) (Station, error)
+	Nearby         func(current Station, favorites []Station) (Station, error)
+	DescribeNearby func(station Station) Info
+	Tune           func(station Station, result chan StationStream)
+	Monitor        func(stream *StationStream, stalled func())
+	Alive          func(stream *StationStream) bool
+	Identify       func(result chan Track)
+	AddTrack       func(track Track) error // nil without Spotify
+	SaveFavorites  func(stations []Station) error
+	SavePresets    func(presets Presets) error
+	SaveStation    func(station Station) error // nil to not remember it
+	LoadProfile    func(profile Profile) ([]Station, Presets)
+	Stats          *Reliability // nil records nothing
+	Reporter       *Reporter    // nil reports nothing
+
+	state    RadioState
+	stopped  bool
+	booting  bool // the boot station has not started yet
+	current  *StationStream
+	muted    bool      // what plays is not heard, so not listened to
+	since    time.Time // since when `current` is listened to, zero when it isn't
+	favIndex int
+	gen      int // bumped on every transition, stale results are dropped
+	commands chan func()
+}
+
+func NewRadio(display *Display, favorites []Station, presets Presets) *Radio {
+	return &Radio{
+		Display:   display,
+		Favorites: favorites,
+		Presets:   presets,
+
+		Search:         get_random_station,
+		Similar:        get_similar_station,
+		LikeFavorites:  get_station_like_favorites,
+		Nearby:         get_station_nearby,
+		DescribeNearby: DescribeNearby,
+		Tune:           nil, // needs the audio sink, see main
+		Monitor: func(stream *StationStream, stalled func()) {
+			stream.Monitor(stalled, display)
+		},
+		Alive: func(stream *StationStream) bool {
+			return stream.Process != nil && stream.Process.Process != nil && isAlive(stream.Process)
+		},
+		SaveFavorites: saveFavoriteStations,
+		SavePresets:   savePresets,
+
+		commands: make(chan func(), 32),
+	}
+}
+
+// Run processes commands until the process exits
+func (r *Radio) Run() {
+	ticker := time.NewTicker(LISTENING_TICK)
+	defer ticker.Stop()
+	for {
+		select {
+		case command := <-r.commands:
+			command()
+		case <-ticker.C:
+			r.countListening()
+		}
+	}
+}
+
+func (r *Radio) do(command func()) {
+	r.commands <- command
+}
+
+// State blocks until every command queued before it has run
+func (r *Radio) State() RadioState {
+	reply := make(chan RadioState)
+	r.do(func() { reply <- r.state })
+	return <-reply
+}
+
+// Current is the station that is playing, if any
+func (r *Radio) Current() Station {
+	reply := make(chan Station)
+	r.do(func() {
+		if r.current == nil {
+			reply <- Station{}
+			return
+		}
+		reply <- r.current.Station
+	})
+	return <-reply
+}
+
+// Stop takes the current station off air and ignores every command and
+// result that comes after it
+func (r *Radio) Stop() {
+	done := make(chan bool)
+	r.do(func() {
+		r.stopped = true
+		r.gen++
+		r.countListening()
+		if r.current != nil {
+			r.current.Stop()
+		}
+		done <- true
+	})
+	<-done
+}
+
+// Start tunes the station played last, or any station at all. If it
+// doesn't start, a favorite gets a go before falling back to a search.
+func (r *Radio) Start(station Station) {
+	r.do(func() {
+		if r.state != RADIO_BOOTING || r.stopped {
+			return
+		}
+		r.booting = true
+		r.tune(station)
+	})
+}
+
+func (r *Radio) PlayRandom() {
+	r.do(func() {
+		if r.busy() {
+			return
+		}
+		r.search()
+	})
+}
+
+// PlaySimilar looks for a station with tags like the current one
+func (r *Radio) PlaySimilar() {
+	r.do(func() {
+		if r.busy() {
+			return
+		}
+		r.searchLike(r.Similar, nil)
+	})
+}
+
+// PlayLikeFavorites looks for a station with tags like the favorites
+func (r *Radio) PlayLikeFavorites() {
+	r.do(func() {
+		if r.busy() {
+			return
+		}
+		r.searchLike(r.LikeFavorites, nil)
+	})
+}
+
+// PlayNearby travels to a station near one of the configured places and
+// tells where it is once it plays
+func (r *Radio) PlayNearby() {
+	r.do(func() {
+		if r.busy() {
+			return
+		}
+		r.searchLike(r.Nearby, r.DescribeNearby)
+	})
+}
+
+// PlayFavorite plays any favorite but the current one
+func (r *Radio) PlayFavorite() {
+	r.do(func() {
+		if r.busy() || len(r.Favorites) == 0 {
+			return
+		}
+		otherStations := r.liveFavorites(r.otherFavorites(r.currentStation()))
+		if len(otherStations) == 0 {
+			otherStations = r.Favorites
+		}
+		r.Display.ShowStatus <- PLAYFAV
+		r.tune(r.pickFavorite(otherStations))
+	})
+}
+
+// pickFavorite favours the favorites listened to the most
+func (r *Radio) pickFavorite(favorites []Station) Station {
+	station, ok := r.Stats.PickBy(favorites, func(station Station) float64 {
+		return ListeningWeight(r.Stats.Get(station.UUID))
+	})
+	if !ok {
+		return PickOne(favorites)
+	}
+	return station
+}
+
+// ShowStats puts how much the current station was listened to on screen
+func (r *Radio) ShowStats() {
+	r.do(func() {
+		station := r.currentStation()
+		if station.UUID == "" || r.state != RADIO_PLAYING {
+			r.Display.ShowStatus <- HUH
+			return
+		}
+		stats := r.Stats.Get(station.UUID)
+		if !r.since.IsZero() {
+			stats.Listened += time.Since(r.since)
+		}
+		r.Display.ShowInfo <- DescribeListening(station, stats)
+	})
+}
+
+// StepFavorite moves through the favorites in order, wrapping around.
+// It steps from the current station if that is a favorite, however it
+// came to play.
+func (r *Radio) StepFavorite(steps int) {
+	r.do(func() {
+		n := len(r.Favorites)
+		if r.busy() || n == 0 {
+			return
+		}
+		if i := r.favoritePosition(r.currentStation()); i >= 0 {
+			r.favIndex = i
+		}
+		r.favIndex = r.nextLiveFavorite(r.favIndex, steps)
+		fmt.Printf("[FAVORITES] [%d/%d]\n", r.favIndex+1, n)
+		r.Display.ShowStatus <- PLAYFAV
+		position := fmt.Sprintf("%d/%d", r.favIndex+1, n)
+		r.tuneAndDescribe(r.Favorites[r.favIndex], func(station Station) Info {
+			return Info{Lines: []string{station.Name, position}}
+		})
+	})
+}
+
+// nextLiveFavorite is the index `steps` on from `from`, and on by as many
+// `steps` again past dead favorites. If they are all dead, so be it.
+func (r *Radio) nextLiveFavorite(from int, steps int) int {
+	n := len(r.Favorites)
+	for i := 1; i <= n; i++ {
+		index := ((from+i*steps)%n + n) % n
+		station := r.Favorites[index]
+		if !r.Stats.Get(station.UUID).Dead() {
+			return index
+		}
+		fmt.Printf("[FAVORITES] Skipping dead %s\n", station.Name)
+	}
+	return ((from+steps)%n + n) % n
+}
+
+// MoveFavorite moves the current station `steps` places up or down the
+// favorites, stopping at either end
+func (r *Radio) MoveFavorite(steps int) {
+	r.do(func() {
+		from := r.favoritePosition(r.currentStation())
+		if from < 0 {
+			r.Display.ShowStatus <- HUH
+			return
+		}
+		to := from + steps
+		if to < 0 {
+			to = 0
+		}
+		if to > len(r.Favorites)-1 {
+			to = len(r.Favorites) - 1
+		}
+		if to == from {
+			return
+		}
+		favorites := append([]Station{}, r.Favorites...)
+		station := favorites[from]
+		favorites = append(favorites[:from], favorites[from+1:]...)
+		favorites = append(favorites[:to], append([]Station{station}, favorites[to:]...)...)
+		if err := r.SaveFavorites(favorites); err != nil {
+			fmt.Printf("Failed to save favorite stations: %s\n", err)
+			return
+		}
+		r.Favorites = favorites
+		r.favIndex = to
+		fmt.Printf("[FAVORITES] [%d/%d] Moved: %s\n", to+1, len(favorites), station.Name)
+		r.Display.ShowInfo <- Info{Lines: []string{station.Name, fmt.Sprintf("%d/%d", to+1, len(favorites))}}
+	})
+}
+
+func (r *Radio) PlayPreset(slot int) {
+	r.do(func() {
+		station, ok := r.Presets.Station(slot, r.Favorites)
+		if !ok {
+			fmt.Printf("[PRESETS] Slot %d is empty\n", slot)
+			r.Display.ShowStatus <- HUH
+			return
+		}
+		if r.busy() {
+			return
+		}
+		fmt.Printf("[PRESETS] [%d] %s\n", slot, station.Name)
+		r.Display.ShowStatus <- PLAYFAV
+		r.tune(station)
+	})
+}
+
+func (r *Radio) AddFavorite() {
+	r.do(func() {
+		station := r.currentStation()
+		if station.UUID == "" {
+			return
+		}
+		r.Display.ShowStatus <- ADDFAV
+		for _, favorite := range r.Favorites {
+			if favorite.UUID == station.UUID {
+				return
+			}
+		}
+		favorites := append(append([]Station{}, r.Favorites...), station)
+		if err := r.SaveFavorites(favorites); err != nil {
+			fmt.Printf("Failed to save favorite station: %s\n", err)
+			return
+		}
+		r.Favorites = favorites
+		r.updatePresets()
+		fmt.Printf("[FAVORITES] [%d] Added: %s\n", len(r.Favorites), station.Name)
+		r.Reporter.Vote(station)
+	})
+}
+
+func (r *Radio) RemoveFavorite() {
+	r.do(func() {
+		station := r.currentStation()
+		if !r.isFavorite(station) {
+			r.Display.ShowStatus <- HUH
+			return
+		}
+		r.Display.ShowStatus <- TRASH
+		fmt.Println("Removing station: ", station.Name)
+		favorites := []Station{}
+		for _, favorite := range r.Favorites {
+			if favorite.UUID != station.UUID {
+				favorites = append(favorites, favorite)
+			}
+		}
+		if err := r.SaveFavorites(favorites); err != nil {
+			fmt.Printf("Failed to save favorite stations: %s\n", err)
+			return
+		}
+		r.Favorites = favorites
+		r.updatePresets()
+	})
+}
+
+func (r *Radio) IdentifySong() {
+	r.do(func() {
+		if r.Identify == nil || r.state != RADIO_PLAYING || r.stopped {
+			return
+		}
+		r.setState(RADIO_IDENTIFYING)
+		r.Display.ShowStatus <- IDENTIFY
+		gen := r.gen
+		result := make(chan Track, 1)
+		go r.Identify(result)
+		go func() {
+			track := <-result
+			r.do(func() { r.identified(gen, track) })
+		}()
+	})
+}
+
+func (r *Radio) busy() bool {
+	if r.stopped {
+		return true
+	}
+	switch r.state {
+	case RADIO_SEARCHING, RADIO_TUNING, RADIO_IDENTIFYING:
+		fmt.Println("[BUSY]")
+		return true
+	}
+	return false
+}
+
+func (r *Radio) currentStation() Station {
+	if r.current == nil {
+		return Station{}
+	}
+	return r.current.Station
+}
+
+// otherFavorites are the favorites that are not `station`
+func (r *Radio) otherFavorites(station Station) []Station {
+	otherStations := []Station{}
+	for _, favorite := range r.Favorites {
+		if favorite.UUID != station.UUID {
+			otherStations = append(otherStations, favorite)
+		}
+	}
+	return otherStations
+}
+
+// liveFavorites leaves out the favorites flagged as dead, unless that
+// leaves nothing
+func (r *Radio) liveFavorites(stations []Station) []Station {
+	live := []Station{}
+	for _, station := range stations {
+		if !r.Stats.Get(station.UUID).Dead() {
+			live = append(live, station)
+		}
+	}
+	if len(live) == 0 {
+		return stations
+	}
+	return live
+}
+
+func (r *Radio) isFavorite(station Station) bool {
+	return r.favoritePosition(station) >= 0
+}
+
+// favoritePosition is the index of `station` in the favorites, or -1
+func (r *Radio) favoritePosition(station Station) int {
+	if station.UUID == "" {
+		return -1
+	}
+	for i, favorite := range r.Favorites {
+		if favorite.UUID == station.UUID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Radio) setState(state RadioState) {
+	if state != r.state {
+		fmt.Printf("[RADIO] %s -> %s\n", r.state, state)
+	}
+	r.state = state
+	r.gen++
+	r.countListening()
+	if timeout, ok := RADIO_TIMEOUTS[state]; ok {
+		gen := r.gen
+		time.AfterFunc(timeout, func() {
+			r.do(func() { r.timedOut(gen) })
+		})
+	}
+}
+
+// SetMuted tells the radio whether it can be heard
+func (r *Radio) SetMuted(muted bool) {
+	r.do(func() {
+		r.muted = muted
+		r.countListening()
+	})
+}
+
+// listening is true while `current` is on air and heard. A search or a
+// stall means it isn't worth listening to anymore.
+func (r *Radio) listening() bool {
+	if r.current == nil || r.stopped || r.muted {
+		return false
+	}
+	return r.state == RADIO_PLAYING || r.state == RADIO_IDENTIFYING
+}
+
+// countListening adds the time listened since the last count to `current`,
+// then starts the clock again if it is still listened to
+func (r *Radio) countListening() {
+	if !r.since.IsZero() && r.current != nil {
+		r.Stats.Listened(r.current.Station, time.Since(r.since))
+	}
+	r.since = time.Time{}
+	if r.listening() {
+		r.since = time.Now()
+	}
+}
+
+// settle returns to PLAYING if a station is still on air, ERROR otherwise
+func (r *Radio) settle() {
+	if r.current != nil && r.Alive(r.current) {
+		r.setState(RADIO_PLAYING)
+		r.Display.ShowStatus <- ERROR
+		return
+	}
+	r.setState(RADIO_ERROR)
+	r.Display.ShowStatus <- ERROR
+}
+
+func (r *Radio) search() {
+	fmt.Println("[STATIONS] Getting random station")
+	search := r.Search
+	r.searchLike(func(current Station, favorites []Station) (Station, error) {
+		return search(current)
+	}, nil)
+}
+
+// searchLike runs `find` with the current station and a copy of the
+// favorites. If the station it finds plays, `describe` says what to show.
+func (r *Radio) searchLike(find func(current Station, favorites []Station) (Station, error), describe func(station Station) Info) {
+	r.setState(RADIO_SEARCHING)
+	r.Display.ShowStatus <- SEARCH
+	gen := r.gen
+	current := r.currentStation()
+	favorites := append([]Station{}, r.Favorites...)
+	go func() {
+		station, err := find(current, favorites)
+		r.do(func() { r.searched(gen, station, err, describe) })
+	}()
+}
+
+func (r *Radio) searched(gen int, station Station, err error, describe func(station Station) Info) {
+	if gen != r.gen {
+		return
+	}
+	if err != nil {
+		fmt.Printf("[STATIONS] %s\n", err)
+		r.settle()
+		return
+	}
+	r.tuneAndDescribe(station, describe)
+}
+
+func (r *Radio) tune(station Station) {
+	r.tuneAndDescribe(station, nil)
+}
+
+func (r *Radio) tuneAndDescribe(station Station, describe func(station Station) Info) {
+	r.setState(RADIO_TUNING)
+	gen := r.gen
+	result := make(chan StationStream, 1)
+	go r.Tune(station, result)
+	go func() {
+		stream := <-result
+		r.do(func() { r.tuned(gen, stream, describe) })
+	}()
+}
+
+func (r *Radio) tuned(gen int, stream StationStream, describe func(station Station) Info) {
+	if gen != r.gen {
+		// We gave up on this one already, don't let it play over the current station
+		if stream.Started {
+			stream.Stop()
+		}
+		return
+	}
+	if !stream.Started {
+		fmt.Println("[TIMEOUT] Station did not start")
+		r.Stats.Failed(stream.Station)
+		if r.isFavorite(stream.Station) && r.Stats.Get(stream.UUID).Dead() {
+			fmt.Printf("[FAVORITES] Looks dead: %s\n", stream.Name)
+		}
+		// The station we were playing before a restart may be gone, a
+		// favorite is a better bet than a random one
+		if r.booting {
+			r.booting = false
+			if otherStations := r.otherFavorites(stream.Station); len(otherStations) > 0 {
+				fmt.Println("[RESUME] Falling back to a favorite")
+				r.tune(PickOne(otherStations))
+				return
+			}
+		}
+		// If a station is still playing, then let the user manually try again
+		if r.current != nil && r.Alive(r.current) {
+			r.setState(RADIO_PLAYING)
+			r.Display.ShowStatus <- ERROR
+			return
+		}
+		// If nothing is playing, then try again automatically. Note, if stations
+		// keep failing (in the case of the network being down), this will loop
+		// until the search itself fails
+		r.search()
+		return
+	}
+	fmt.Printf("[ SET ]: %s\n", stream.Name)
+	r.booting = false
+	r.Stats.Started(stream.Station, stream.StartedIn)
+	r.Reporter.Click(stream.Station)
+	// Only now that the new station is ours to play, the old one goes
+	if r.current != nil {
+		r.current.Stop()
+	}
+	r.current = &stream
+	if r.SaveStation != nil {
+		if err := r.SaveStation(stream.Station); err != nil {
+			fmt.Printf("[RESUME] Failed to save station: %s\n", err)
+		}
+	}
+	r.setState(RADIO_PLAYING)
+	r.Display.ShowStatus <- PLAYING
+	if describe != nil {
+		r.Display.ShowInfo <- describe(stream.Station)
+	}
+	if r.Monitor != nil {
+		go r.Monitor(&stream, func() {
+			r.do(func() { r.stalled(&stream) })
+		})
+	}
+}
+
+func (r *Radio) stalled(stream *StationStream) {
+	if stream != r.current || r.stopped {
+		return
+	}
+	// An identification in progress is of no use on a dead stream
+	if r.state != RADIO_PLAYING && r.state != RADIO_IDENTIFYING {
+		return
+	}
+	r.Stats.Stalled(stream.Station)
+	r.search()
+}
+
+func (r *Radio) identified(gen int, track Track) {
+	if gen != r.gen {
+		return
+	}
+	r.setState(RADIO_PLAYING)
+	if !track.OK {
+		r.Display.ShowStatus <- HUH
+		return
+	}
+	if r.AddTrack == nil || track.SpotifyID == "" {
+		escaped := url.QueryEscape(track.Title + " " + track.Artist)
+		yt_seatrch_url := YOUTUBE_SEARCH + escaped
+		r.Display.ShowQR <- QR{yt_seatrch_url, 60, PLAYING}
+		return
+	}
+	if err := r.AddTrack(track); err != nil {
+		fmt.Printf("[SPOTIFY] %s\n", err)
+		r.Display.ShowStatus <- ERROR
+		return
+	}
+	fmt.Printf("[SPOTIFY] Added: %s - %s\n", track.Title, track.Artist)
+	r.Display.ShowStatus <- ADDFAV
+}
+
+func (r *Radio) timedOut(gen int) {
+	if gen != r.gen {
+		return
+	}
+	fmt.Printf("[RADIO] Gave up %s after %s\n", r.state, RADIO_TIMEOUTS[r.state])
+	r.booting = false
+	if r.state == RADIO_IDENTIFYING {
+		r.setState(RADIO_PLAYING)
+		r.Display.ShowStatus <- HUH
+		return
+	}
+	r.settle()
+}
+
+// ListFavorites is a copy of the favorites, for goroutines other than Run
+func (r *Radio) ListFavorites() []Station {
+	reply := make(chan []Station)
+	r.do(func() { reply <- append([]Station{}, r.Favorites...) })
+	return <-reply
+}
+
+// UpdateFavorite replaces the favorite with the UUID of `station`, if it
+// still is one. What is playing keeps playing.
+func (r *Radio) UpdateFavorite(station Station) {
+	r.do(func() {
+		i := r.favoritePosition(station)
+		if i < 0 {
+			return
+		}
+		favorites := append([]Station{}, r.Favorites...)
+		favorites[i] = station
+		if err := r.SaveFavorites(favorites); err != nil {
+			fmt.Printf("Failed to save favorite stations: %s\n", err)
+			return
+		}
+		r.Favorites = favorites
+	})
+}
+
+// SwitchProfile swaps in the favorites and presets of `profile`. What is
+// playing keeps playing.
+func (r *Radio) SwitchProfile(profile Profile) {
+	r.do(func() {
+		r.Favorites, r.Presets = r.LoadProfile(profile)
+		r.favIndex = 0
+		r.updatePresets()
+		fmt.Printf("[PROFILE] %s: %d favorites\n", profile.Name, len(r.Favorites))
+		r.Display.ShowInfo <- Info{Lines: []string{profile.Name, fmt.Sprintf("%d FAVORITES", len(r.Favorites))}}
+	})
+}
+
+func (r *Radio) updatePresets() {
+	if r.Presets.Assign(r.Favorites) {
+		if err := r.SavePresets(r.Presets); err != nil {
+			fmt.Printf("[PRESETS] Failed to save: %s\n", err)
+		}
+	}
+}
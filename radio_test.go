@@ -0,0 +1,591 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	testStationA = Station{Name: "A", UUID: "uuid-a", URL: "http://a"}
+	testStationB = Station{Name: "B", UUID: "uuid-b", URL: "http://b"}
+	testStationC = Station{Name: "C", UUID: "uuid-c", URL: "http://c"}
+)
+
+type tuneRequest struct {
+	station Station
+	result  chan StationStream
+}
+
+// fakeRadio records what the radio asks of its dependencies and lets
+// the test decide the outcome
+type fakeRadio struct {
+	*Radio
+	tunes    chan tuneRequest
+	searches chan chan searchResult
+	monitors chan func()
+	alive    int32
+	saved    [][]Station
+}
+
+type searchResult struct {
+	station Station
+	err     error
+}
+
+func newTestRadio(t *testing.T) *fakeRadio {
+	t.Helper()
+	display := &Display{ShowStatus: make(chan int, 256), ShowQR: make(chan QR, 16), ShowInfo: make(chan Info, 16)}
+	f := &fakeRadio{
+		Radio:    NewRadio(display, []Station{testStationA, testStationB}, Presets{}),
+		tunes:    make(chan tuneRequest, 4),
+		searches: make(chan chan searchResult, 4),
+		monitors: make(chan func(), 4),
+	}
+	f.Tune = func(station Station, result chan StationStream) {
+		f.tunes <- tuneRequest{station, result}
+	}
+	f.Search = func(current Station) (Station, error) {
+		reply := make(chan searchResult)
+		f.searches <- reply
+		res := <-reply
+		return res.station, res.err
+	}
+	f.Monitor = func(stream *StationStream, stalled func()) {
+		f.monitors <- stalled
+	}
+	f.Alive = func(stream *StationStream) bool {
+		return atomic.LoadInt32(&f.alive) == 1
+	}
+	f.SaveFavorites = func(stations []Station) error {
+		f.saved = append(f.saved, stations)
+		return nil
+	}
+	f.SavePresets = func(Presets) error { return nil }
+	go f.Run()
+	return f
+}
+
+func (f *fakeRadio) expectState(t *testing.T, state RadioState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if f.State() == state {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %s, radio is %s", state, f.State())
+}
+
+func (f *fakeRadio) expectTune(t *testing.T) tuneRequest {
+	t.Helper()
+	select {
+	case req := <-f.tunes:
+		return req
+	case <-time.After(time.Second):
+		t.Fatal("Radio did not tune")
+	}
+	return tuneRequest{}
+}
+
+func (f *fakeRadio) expectSearch(t *testing.T) chan searchResult {
+	t.Helper()
+	select {
+	case reply := <-f.searches:
+		return reply
+	case <-time.After(time.Second):
+		t.Fatal("Radio did not search")
+	}
+	return nil
+}
+
+func (f *fakeRadio) expectStatus(t *testing.T, status int) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case s := <-f.Display.ShowStatus:
+			if s == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Status %d was not shown", status)
+		}
+	}
+}
+
+// play brings the radio to PLAYING on `station`
+func (f *fakeRadio) play(t *testing.T, station Station) {
+	t.Helper()
+	f.Start(station)
+	req := f.expectTune(t)
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	atomic.StoreInt32(&f.alive, 1)
+}
+
+func TestRadioBootToPlaying(t *testing.T) {
+	f := newTestRadio(t)
+	if f.State() != RADIO_BOOTING {
+		t.Fatalf("Expected BOOTING, radio is %s", f.State())
+	}
+	f.Start(testStationA)
+	req := f.expectTune(t)
+	if req.station.UUID != testStationA.UUID {
+		t.Errorf("Tuned %s instead of %s", req.station.Name, testStationA.Name)
+	}
+	f.expectState(t, RADIO_TUNING)
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	f.expectStatus(t, PLAYING)
+	if f.Current().UUID != testStationA.UUID {
+		t.Errorf("Current station is %s", f.Current().Name)
+	}
+}
+
+func TestRadioTuneFailsWithNothingPlaying(t *testing.T) {
+	f := newTestRadio(t)
+	f.Start(testStationA)
+	req := f.expectTune(t)
+	req.result <- StationStream{Station: req.station}
+	// The boot station gets a favorite as its fallback
+	req = f.expectTune(t)
+	if req.station.UUID != testStationB.UUID {
+		t.Errorf("Fell back to %s instead of %s", req.station.Name, testStationB.Name)
+	}
+	req.result <- StationStream{Station: req.station}
+	// Nothing on air, so it goes looking for something else by itself
+	f.expectState(t, RADIO_SEARCHING)
+	f.expectSearch(t) <- searchResult{station: testStationC}
+	req = f.expectTune(t)
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	if f.Current().UUID != testStationC.UUID {
+		t.Errorf("Current station is %s", f.Current().Name)
+	}
+}
+
+func TestRadioResume(t *testing.T) {
+	f := newTestRadio(t)
+	saved := make(chan Station, 4)
+	f.SaveStation = func(station Station) error {
+		saved <- station
+		return nil
+	}
+	f.Start(testStationC)
+	req := f.expectTune(t)
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	select {
+	case station := <-saved:
+		if station.UUID != testStationC.UUID {
+			t.Errorf("Saved %s instead of %s", station.Name, testStationC.Name)
+		}
+	case <-time.After(time.Second):
+		t.Error("Station was not saved")
+	}
+	atomic.StoreInt32(&f.alive, 1)
+
+	// Only the boot station falls back to a favorite
+	f.PlayRandom()
+	f.expectSearch(t) <- searchResult{station: testStationB}
+	f.expectTune(t).result <- StationStream{Station: testStationB}
+	f.expectState(t, RADIO_PLAYING)
+	select {
+	case req := <-f.tunes:
+		t.Errorf("Tuned %s after the boot station had played", req.station.Name)
+	default:
+	}
+}
+
+func TestRadioTuneFailsWhilePlaying(t *testing.T) {
+	f := newTestRadio(t)
+	f.play(t, testStationA)
+	f.PlayFavorite()
+	req := f.expectTune(t)
+	req.result <- StationStream{Station: req.station}
+	f.expectState(t, RADIO_PLAYING)
+	f.expectStatus(t, ERROR)
+	if f.Current().UUID != testStationA.UUID {
+		t.Errorf("Current station is %s", f.Current().Name)
+	}
+}
+
+func TestRadioSearch(t *testing.T) {
+	f := newTestRadio(t)
+	f.play(t, testStationA)
+
+	f.PlayRandom()
+	f.expectState(t, RADIO_SEARCHING)
+	f.expectSearch(t) <- searchResult{station: testStationC}
+	req := f.expectTune(t)
+	if req.station.UUID != testStationC.UUID {
+		t.Errorf("Tuned %s instead of %s", req.station.Name, testStationC.Name)
+	}
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+
+	// A failed search falls back to what is still playing
+	f.PlayRandom()
+	f.expectSearch(t) <- searchResult{err: errors.New("offline")}
+	f.expectState(t, RADIO_PLAYING)
+	f.expectStatus(t, ERROR)
+
+	// Unless nothing is
+	atomic.StoreInt32(&f.alive, 0)
+	f.PlayRandom()
+	f.expectSearch(t) <- searchResult{err: errors.New("offline")}
+	f.expectState(t, RADIO_ERROR)
+}
+
+func TestRadioSimilar(t *testing.T) {
+	f := newTestRadio(t)
+	seeds := make(chan Station, 1)
+	f.Similar = func(current Station, favorites []Station) (Station, error) {
+		seeds <- current
+		return testStationC, nil
+	}
+	f.play(t, testStationA)
+	f.PlaySimilar()
+	req := f.expectTune(t)
+	if req.station.UUID != testStationC.UUID {
+		t.Errorf("Tuned %s instead of %s", req.station.Name, testStationC.Name)
+	}
+	if seed := <-seeds; seed.UUID != testStationA.UUID {
+		t.Errorf("Seeded with %s instead of the current station", seed.Name)
+	}
+}
+
+func TestRadioNearby(t *testing.T) {
+	f := newTestRadio(t)
+	f.Nearby = func(current Station, favorites []Station) (Station, error) {
+		return testStationC, nil
+	}
+	f.DescribeNearby = func(station Station) Info {
+		return Info{Lines: []string{station.Name, "Far away"}}
+	}
+	f.play(t, testStationA)
+	f.PlayNearby()
+	f.expectTune(t).result <- StationStream{Station: testStationC, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	select {
+	case info := <-f.Display.ShowInfo:
+		if info.Lines[0] != testStationC.Name {
+			t.Errorf("Unexpected info: %v", info.Lines)
+		}
+	case <-time.After(time.Second):
+		t.Error("No info shown")
+	}
+
+	// Only the nearby station gets a card
+	f.PlayFavorite()
+	f.expectTune(t).result <- StationStream{Station: testStationB, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	select {
+	case info := <-f.Display.ShowInfo:
+		t.Errorf("Unexpected info: %v", info.Lines)
+	default:
+	}
+}
+
+func TestRadioErrorRecovers(t *testing.T) {
+	f := newTestRadio(t)
+	f.PlayRandom()
+	f.expectSearch(t) <- searchResult{err: errors.New("offline")}
+	f.expectState(t, RADIO_ERROR)
+	f.PlayFavorite()
+	f.expectState(t, RADIO_TUNING)
+	req := f.expectTune(t)
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+}
+
+func TestRadioBusy(t *testing.T) {
+	f := newTestRadio(t)
+	f.Start(testStationA)
+	req := f.expectTune(t)
+	f.PlayRandom()
+	f.PlayFavorite()
+	f.StepFavorite(1)
+	f.expectState(t, RADIO_TUNING)
+	select {
+	case <-f.searches:
+		t.Error("Searched while tuning")
+	case <-f.tunes:
+		t.Error("Tuned twice")
+	default:
+	}
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+}
+
+func TestRadioStalled(t *testing.T) {
+	f := newTestRadio(t)
+	f.play(t, testStationA)
+	var stalled func()
+	select {
+	case stalled = <-f.monitors:
+	case <-time.After(time.Second):
+		t.Fatal("Stream is not monitored")
+	}
+	stalled()
+	f.expectState(t, RADIO_SEARCHING)
+	f.expectSearch(t) <- searchResult{station: testStationC}
+	f.expectTune(t).result <- StationStream{Station: testStationC, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+
+	// The old stream stalling again is old news
+	stalled()
+	f.expectState(t, RADIO_PLAYING)
+}
+
+func TestRadioIdentify(t *testing.T) {
+	f := newTestRadio(t)
+	tracks := make(chan Track)
+	f.Identify = func(result chan Track) {
+		result <- <-tracks
+	}
+
+	// Only a playing radio can identify
+	f.IdentifySong()
+	f.expectState(t, RADIO_BOOTING)
+
+	f.play(t, testStationA)
+	f.IdentifySong()
+	f.expectState(t, RADIO_IDENTIFYING)
+	f.PlayRandom()
+	f.expectState(t, RADIO_IDENTIFYING)
+	tracks <- Track{OK: true, Title: "Song", Artist: "Band"}
+	f.expectState(t, RADIO_PLAYING)
+	select {
+	case qr := <-f.Display.ShowQR:
+		if qr.String != YOUTUBE_SEARCH+"Song+Band" {
+			t.Errorf("Unexpected QR: %s", qr.String)
+		}
+	case <-time.After(time.Second):
+		t.Error("No QR code shown")
+	}
+
+	added := make(chan Track, 1)
+	f.AddTrack = func(track Track) error {
+		added <- track
+		return nil
+	}
+	f.IdentifySong()
+	tracks <- Track{OK: true, SpotifyID: "spotify-id"}
+	f.expectState(t, RADIO_PLAYING)
+	if track := <-added; track.SpotifyID != "spotify-id" {
+		t.Errorf("Added %s to Spotify", track.SpotifyID)
+	}
+
+	f.IdentifySong()
+	tracks <- Track{OK: false}
+	f.expectState(t, RADIO_PLAYING)
+	f.expectStatus(t, HUH)
+}
+
+func TestRadioTimeout(t *testing.T) {
+	timeouts := RADIO_TIMEOUTS
+	RADIO_TIMEOUTS = map[RadioState]time.Duration{RADIO_TUNING: 20 * time.Millisecond}
+	defer func() { RADIO_TIMEOUTS = timeouts }()
+
+	f := newTestRadio(t)
+	f.Start(testStationA)
+	req := f.expectTune(t)
+	f.expectState(t, RADIO_ERROR)
+
+	// A station that turns up after we gave up on it must not play
+	f.Tune = func(station Station, result chan StationStream) {}
+	process := startSleeper(t)
+	req.result <- StationStream{Station: req.station, Process: process, Started: true}
+	f.State()
+	done := make(chan error)
+	go func() { done <- process.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Late station was not stopped")
+	}
+	if f.State() != RADIO_ERROR {
+		t.Errorf("Expected ERROR, radio is %s", f.State())
+	}
+}
+
+func TestRadioStaleStreamKeepsCurrent(t *testing.T) {
+	timeouts := RADIO_TIMEOUTS
+	RADIO_TIMEOUTS = map[RadioState]time.Duration{RADIO_TUNING: 20 * time.Millisecond}
+	defer func() { RADIO_TIMEOUTS = timeouts }()
+
+	f := newTestRadio(t)
+	current := startSleeper(t)
+	currentDone := waitFor(current)
+	f.Start(testStationA)
+	f.expectTune(t).result <- StationStream{Station: testStationA, Process: current, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	atomic.StoreInt32(&f.alive, 1)
+
+	// B takes too long, A plays on
+	f.PlayFavorite()
+	req := f.expectTune(t)
+	f.expectStatus(t, ERROR)
+	f.expectState(t, RADIO_PLAYING)
+
+	// Then B starts after all, it goes and A stays
+	late := startSleeper(t)
+	req.result <- StationStream{Station: req.station, Process: late, Started: true}
+	f.State()
+	if !exited(waitFor(late), time.Second) {
+		t.Error("Late station was not stopped")
+	}
+	if exited(currentDone, 50*time.Millisecond) {
+		t.Error("Late station stopped the current one")
+	}
+	if f.State() != RADIO_PLAYING || f.Current().UUID != testStationA.UUID {
+		t.Errorf("Expected A to play, radio is %s on %s", f.State(), f.Current().Name)
+	}
+
+	// A station that starts in time replaces A
+	f.PlayFavorite()
+	f.expectTune(t).result <- StationStream{Station: testStationB, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	if !exited(currentDone, time.Second) {
+		t.Error("Previous station was not stopped")
+	}
+}
+
+func TestRadioStepAndMoveFavorites(t *testing.T) {
+	f := newTestRadio(t)
+	f.Favorites = []Station{testStationA, testStationB, testStationC}
+
+	// Stepping starts from wherever the current favorite is
+	f.play(t, testStationB)
+	f.StepFavorite(1)
+	req := f.expectTune(t)
+	if req.station.UUID != testStationC.UUID {
+		t.Fatalf("Stepped to %s instead of C", req.station.Name)
+	}
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	f.StepFavorite(1)
+	req = f.expectTune(t)
+	if req.station.UUID != testStationA.UUID {
+		t.Fatalf("Expected to wrap around to A, got %s", req.station.Name)
+	}
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+
+	// A moves down to the end, and stays there
+	f.MoveFavorite(1)
+	f.MoveFavorite(1)
+	f.MoveFavorite(1)
+	f.State()
+	order := ""
+	for _, station := range f.Favorites {
+		order += station.Name
+	}
+	if order != "BCA" {
+		t.Errorf("Expected BCA, got %s", order)
+	}
+	if len(f.saved) != 2 {
+		t.Errorf("Expected 2 saves, got %d", len(f.saved))
+	}
+	f.StepFavorite(-1)
+	if req := f.expectTune(t); req.station.UUID != testStationC.UUID {
+		t.Errorf("Stepped back to %s instead of C", req.station.Name)
+	}
+}
+
+func TestRadioSwitchProfile(t *testing.T) {
+	f := newTestRadio(t)
+	f.LoadProfile = func(profile Profile) ([]Station, Presets) {
+		return []Station{testStationC}, Presets{}
+	}
+	f.play(t, testStationA)
+	f.SwitchProfile(Profile{Name: "Kids"})
+	if info := <-f.Display.ShowInfo; info.Lines[0] != "Kids" {
+		t.Errorf("Expected the profile on screen, got %v", info.Lines)
+	}
+	if slot, _ := f.Presets.Station(0, f.Favorites); slot.UUID != testStationC.UUID {
+		t.Errorf("Expected C on the first preset, got %v", f.Presets)
+	}
+
+	// A keeps playing, and becomes a favorite of this profile only
+	f.AddFavorite()
+	f.State()
+	if len(f.saved) != 1 || len(f.saved[0]) != 2 || f.saved[0][1].UUID != testStationA.UUID {
+		t.Errorf("Expected C and A saved, got %v", f.saved)
+	}
+}
+
+func TestRadioFavoritesAndPresets(t *testing.T) {
+	f := newTestRadio(t)
+	f.Presets.Assign(f.Favorites)
+	f.play(t, testStationC)
+
+	f.AddFavorite()
+	f.AddFavorite()
+	f.State()
+	if len(f.saved) != 1 || len(f.saved[0]) != 3 {
+		t.Fatalf("Expected one save with 3 favorites, got %v", f.saved)
+	}
+	if f.Presets[2] != testStationC.UUID {
+		t.Errorf("Expected C on preset 2, presets are %v", f.Presets)
+	}
+
+	// Removing A frees its slot, C stays where it is
+	f.StepFavorite(-2)
+	req := f.expectTune(t)
+	if req.station.UUID != testStationA.UUID {
+		t.Fatalf("Stepped to %s", req.station.Name)
+	}
+	req.result <- StationStream{Station: req.station, Started: true}
+	f.expectState(t, RADIO_PLAYING)
+	f.RemoveFavorite()
+	f.State()
+	if _, ok := f.Presets[0]; ok || f.Presets[2] != testStationC.UUID {
+		t.Errorf("Unexpected presets after remove: %v", f.Presets)
+	}
+
+	// A is no favorite anymore, there is nothing to remove
+	saves := len(f.saved)
+	f.RemoveFavorite()
+	f.expectStatus(t, HUH)
+	if len(f.saved) != saves {
+		t.Errorf("Saved the favorites without a change: %v", f.saved)
+	}
+
+	f.PlayPreset(2)
+	req = f.expectTune(t)
+	if req.station.UUID != testStationC.UUID {
+		t.Errorf("Preset 2 tuned %s", req.station.Name)
+	}
+}
+
+// waitFor reports when `process` ends
+func waitFor(process *exec.Cmd) chan error {
+	done := make(chan error, 1)
+	go func() { done <- process.Wait() }()
+	return done
+}
+
+// exited waits up to `wait` for `done` from waitFor
+func exited(done chan error, wait time.Duration) bool {
+	select {
+	case <-done:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func startSleeper(t *testing.T) *exec.Cmd {
+	t.Helper()
+	sleep := exec.Command("sleep", "10")
+	if err := sleep.Start(); err != nil {
+		t.Skipf("Can't start sleep: %s", err)
+	}
+	return sleep
+}
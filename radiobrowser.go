@@ -0,0 +1,295 @@
+package main
+
+// API docs: https://de1.api.radio-browser.info
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	RADIO_BROWSER_DOMAIN = "radio-browser.info"
+	// Used when the SRV lookup fails
+	RADIO_BROWSER_SERVERS = []string{`de1.api.radio-browser.info`, `at1.api.radio-browser.info`, `nl1.api.radio-browser.info`}
+	RADIO_BROWSER_TIMEOUT = 10 * time.Second
+	// A failing mirror is tried last for BACKOFF, doubling up to MAX_BACKOFF
+	RADIO_BROWSER_BACKOFF     = 5 * time.Second
+	RADIO_BROWSER_MAX_BACKOFF = 5 * time.Minute
+	// radio-browser asks clients to identify themselves
+	USER_AGENT = "whatradio/1.0"
+
+	RADIO_BROWSER = NewRadioBrowser()
+)
+
+type mirror struct {
+	host      string
+	failures  int
+	downUntil time.Time
+}
+
+// RadioBrowser talks to the radio-browser.info mirrors, moving on to the
+// next one whenever a request fails
+type RadioBrowser struct {
+	Client    *http.Client
+	UserAgent string
+	Scheme    string
+
+	mu      sync.Mutex
+	mirrors []*mirror
+}
+
+func NewRadioBrowser(hosts ...string) *RadioBrowser {
+	if len(hosts) == 0 {
+		hosts = RADIO_BROWSER_SERVERS
+	}
+	rb := &RadioBrowser{
+		Client:    &http.Client{Timeout: RADIO_BROWSER_TIMEOUT},
+		UserAgent: USER_AGENT,
+		Scheme:    "https",
+	}
+	rb.setHosts(hosts)
+	return rb
+}
+
+func (rb *RadioBrowser) setHosts(hosts []string) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.mirrors = []*mirror{}
+	for _, host := range hosts {
+		rb.mirrors = append(rb.mirrors, &mirror{host: host})
+	}
+}
+
+// Discover finds the mirrors through DNS SRV and checks which are up.
+// Without SRV the built in list is kept.
+func (rb *RadioBrowser) Discover() error {
+	_, records, err := net.LookupSRV("api", "tcp", RADIO_BROWSER_DOMAIN)
+	if err == nil && len(records) > 0 {
+		hosts := []string{}
+		for _, srv := range records {
+			// srv.Target looks like `de1.api.radio-browser.info.`
+			hosts = append(hosts, strings.TrimSuffix(srv.Target, "."))
+		}
+		rb.setHosts(hosts)
+	} else {
+		err = fmt.Errorf("SRV lookup failed, using %d known mirrors: %v", len(RADIO_BROWSER_SERVERS), err)
+	}
+	rb.HealthCheck()
+	return err
+}
+
+// HealthCheck asks every mirror for its stats, the ones that don't answer
+// are tried last
+func (rb *RadioBrowser) HealthCheck() {
+	rb.mu.Lock()
+	mirrors := append([]*mirror{}, rb.mirrors...)
+	rb.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, m := range mirrors {
+		wg.Add(1)
+		go func(m *mirror) {
+			defer wg.Done()
+			var stats map[string]interface{}
+			rb.report(m, rb.fetch(m.host, "/json/stats", nil, &stats))
+		}(m)
+	}
+	wg.Wait()
+}
+
+// Hosts are the mirrors in the order they will be tried
+func (rb *RadioBrowser) Hosts() []string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	now := time.Now()
+	mirrors := append([]*mirror{}, rb.mirrors...)
+	// Spread the load over the healthy mirrors
+	rand.Shuffle(len(mirrors), func(i, j int) { mirrors[i], mirrors[j] = mirrors[j], mirrors[i] })
+	sort.SliceStable(mirrors, func(i, j int) bool {
+		iDown, jDown := mirrors[i].downUntil.After(now), mirrors[j].downUntil.After(now)
+		if iDown != jDown {
+			return jDown
+		}
+		return iDown && mirrors[i].downUntil.Before(mirrors[j].downUntil)
+	})
+	hosts := []string{}
+	for _, m := range mirrors {
+		hosts = append(hosts, m.host)
+	}
+	return hosts
+}
+
+// available are the Hosts that are not backed off. When every mirror is
+// down they are all tried anyway, the soonest back first.
+func (rb *RadioBrowser) available() []string {
+	hosts := rb.Hosts()
+	up := []string{}
+	now := time.Now()
+	for _, host := range hosts {
+		if m := rb.mirror(host); m == nil || !rb.down(m, now) {
+			up = append(up, host)
+		}
+	}
+	if len(up) == 0 {
+		return hosts
+	}
+	return up
+}
+
+func (rb *RadioBrowser) down(m *mirror, now time.Time) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return m.downUntil.After(now)
+}
+
+func (rb *RadioBrowser) report(m *mirror, err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if err == nil {
+		m.failures = 0
+		m.downUntil = time.Time{}
+		return
+	}
+	backoff := RADIO_BROWSER_BACKOFF << m.failures
+	if backoff > RADIO_BROWSER_MAX_BACKOFF || backoff <= 0 {
+		backoff = RADIO_BROWSER_MAX_BACKOFF
+	} else {
+		m.failures++
+	}
+	m.downUntil = time.Now().Add(backoff)
+	fmt.Printf("[RADIO-BROWSER] [%s] Backing off for %s: %s\n", m.host, backoff, err)
+}
+
+func (rb *RadioBrowser) mirror(host string) *mirror {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	for _, m := range rb.mirrors {
+		if m.host == host {
+			return m
+		}
+	}
+	return nil
+}
+
+// get decodes the JSON at `path` from the first mirror that answers.
+// Backed off mirrors are skipped, so a hanging mirror costs a timeout once
+// per backoff rather than on every request.
+func (rb *RadioBrowser) get(path string, query url.Values, into interface{}) error {
+	var errs []string
+	for _, host := range rb.available() {
+		err := rb.fetch(host, path, query, into)
+		if m := rb.mirror(host); m != nil {
+			rb.report(m, err)
+		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return errors.New("No radio-browser mirrors")
+	}
+	return fmt.Errorf("All radio-browser mirrors failed: %s", strings.Join(errs, "; "))
+}
+
+func (rb *RadioBrowser) fetch(host string, path string, query url.Values, into interface{}) error {
+	u := url.URL{Scheme: rb.Scheme, Host: host, Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", rb.UserAgent)
+	res, err := rb.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("[%s] %s", host, res.Status)
+	}
+	if err := json.NewDecoder(res.Body).Decode(into); err != nil {
+		return fmt.Errorf("[%s] Bad JSON: %w", host, err)
+	}
+	return nil
+}
+
+// Search runs a station search, see /json/stations/search in the API docs
+func (rb *RadioBrowser) Search(query url.Values) ([]Station, error) {
+	stations := []Station{}
+	if err := rb.get("/json/stations/search", query, &stations); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+func (rb *RadioBrowser) StationByUUID(uuid string) (Station, error) {
+	stations := []Station{}
+	if err := rb.get("/json/stations/byuuid/"+url.PathEscape(uuid), nil, &stations); err != nil {
+		return Station{}, err
+	}
+	if len(stations) == 0 {
+		return Station{}, errors.New("No station matching UUID: " + uuid)
+	}
+	return stations[0], nil
+}
+
+// radio-browser answers clicks and votes with `ok` and a message
+type radioBrowserResult struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// StationsByURL finds the stations streaming from `streamURL`
+func (rb *RadioBrowser) StationsByURL(streamURL string) ([]Station, error) {
+	stations := []Station{}
+	if err := rb.get("/json/stations/byurl", url.Values{"url": {streamURL}}, &stations); err != nil {
+		return nil, err
+	}
+	return stations, nil
+}
+
+// Click tells radio-browser that a station was played, which feeds
+// `clickcount` and `clicktrend`
+func (rb *RadioBrowser) Click(uuid string) error {
+	return rb.feedback("/json/url/" + url.PathEscape(uuid))
+}
+
+// Vote feeds `votes`. radio-browser allows one vote per station every 10
+// minutes from the same address, and answers the others with `ok: false`.
+func (rb *RadioBrowser) Vote(uuid string) error {
+	return rb.feedback("/json/vote/" + url.PathEscape(uuid))
+}
+
+// ErrRejected is radio-browser saying no, asking again won't change its mind
+var ErrRejected = errors.New("Rejected by radio-browser")
+
+// feedback goes to one mirror only. A click that timed out may still have
+// been counted, so it is left to the Reporter to try again later rather
+// than sent on to the next mirror straight away.
+func (rb *RadioBrowser) feedback(path string) error {
+	hosts := rb.Hosts()
+	if len(hosts) == 0 {
+		return errors.New("No radio-browser mirrors")
+	}
+	result := radioBrowserResult{}
+	err := rb.fetch(hosts[0], path, nil, &result)
+	if m := rb.mirror(hosts[0]); m != nil {
+		rb.report(m, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("%w: %s", ErrRejected, result.Message)
+	}
+	return nil
+}
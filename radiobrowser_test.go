@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestRadioBrowser(servers ...*httptest.Server) *RadioBrowser {
+	hosts := []string{}
+	for _, server := range servers {
+		hosts = append(hosts, strings.TrimPrefix(server.URL, "http://"))
+	}
+	rb := NewRadioBrowser(hosts...)
+	rb.Scheme = "http"
+	return rb
+}
+
+func TestRadioBrowserFailover(t *testing.T) {
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	agents := make(chan string, 4)
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		if r.URL.Path != "/json/stations/byuuid/uuid-a" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		w.Write([]byte(`[{"name": "A", "stationuuid": "uuid-a", "url_resolved": "http://a"}]`))
+	}))
+	defer working.Close()
+
+	rb := newTestRadioBrowser(broken, working)
+	workingHost := strings.TrimPrefix(working.URL, "http://")
+	// With every mirror down they are all tried, the soonest back first,
+	// so the broken mirror is tried first
+	rb.report(rb.mirror(strings.TrimPrefix(broken.URL, "http://")), errors.New("test"))
+	rb.report(rb.mirror(workingHost), errors.New("test"))
+	rb.report(rb.mirror(workingHost), errors.New("test"))
+	for i := 0; i < 2; i++ {
+		station, err := rb.StationByUUID("uuid-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if station.Name != "A" {
+			t.Errorf("Got station %s", station.Name)
+		}
+		if agent := <-agents; agent != USER_AGENT {
+			t.Errorf("Sent User-Agent %q", agent)
+		}
+	}
+	// The broken mirror is backed off, so it goes to the back of the queue
+	if hosts := rb.Hosts(); hosts[0] != workingHost {
+		t.Errorf("Expected the working mirror first, got %v", hosts)
+	}
+}
+
+func TestRadioBrowserErrors(t *testing.T) {
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>maintenance</html>`))
+	}))
+	defer garbage.Close()
+	rb := newTestRadioBrowser(garbage)
+	if _, err := rb.Search(url.Values{"language": {"english"}}); err == nil || !strings.Contains(err.Error(), "Bad JSON") {
+		t.Errorf("Expected a JSON error, got %v", err)
+	}
+
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer empty.Close()
+	rb = newTestRadioBrowser(empty)
+	if _, err := rb.StationByUUID("missing"); err == nil {
+		t.Error("Expected an error for an unknown UUID")
+	}
+}
+
+func TestRadioBrowserFeedbackOneMirror(t *testing.T) {
+	requests := make(chan string, 4)
+	timeout := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Path
+		http.Error(w, "timeout", http.StatusGatewayTimeout)
+	}))
+	defer timeout.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Path
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer working.Close()
+
+	rb := newTestRadioBrowser(timeout, working)
+	rb.report(rb.mirror(strings.TrimPrefix(working.URL, "http://")), errors.New("test"))
+	// The click may have counted, it must not go to the working mirror too
+	if err := rb.Click("uuid-a"); err == nil {
+		t.Error("Expected the click to fail")
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected one request, got %d", len(requests))
+	}
+}
+
+func TestRadioBrowserSkipsBackedOff(t *testing.T) {
+	requests := make(chan string, 8)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Host
+		http.Error(w, "down", http.StatusBadGateway)
+	})
+	first, second := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer first.Close()
+	defer second.Close()
+	rb := newTestRadioBrowser(first, second)
+	firstHost := strings.TrimPrefix(first.URL, "http://")
+	rb.report(rb.mirror(firstHost), errors.New("test"))
+
+	if _, err := rb.StationByUUID("uuid-a"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if len(requests) != 1 || <-requests == firstHost {
+		t.Errorf("Expected only the mirror that is up to be tried")
+	}
+	// Now both are down, so both are tried
+	if _, err := rb.StationByUUID("uuid-a"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if len(requests) != 2 {
+		t.Errorf("Expected every mirror tried when all are down, got %d requests", len(requests))
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	RELIABILITY_FILE = "reliability.json"
+	// Failing to start this many times in a row keeps a station out of
+	// random picks for BLOCK_FOR
+	RELIABILITY_BLOCK_FAILURES = 3
+	RELIABILITY_BLOCK_FOR      = 7 * 24 * time.Hour
+	// A favorite failing this many times in a row is flagged as dead, or
+	// failing this many probes in a row
+	RELIABILITY_DEAD_FAILURES = 5
+	RELIABILITY_DEAD_PROBES   = 2
+	// Stations slower than this to start are picked less often
+	RELIABILITY_SLOW_START = 10 * time.Second
+	// How often changed stats are written out, Close writes the rest. The
+	// listening time changes every minute a station plays, writing the file
+	// that often would only wear out the SD card.
+	RELIABILITY_SAVE_INTERVAL = 15 * time.Minute
+
+	RELIABILITY *Reliability // nil until main loads it, which records nothing
+)
+
+// StationStats is what we know about how well a station plays
+type StationStats struct {
+	Name        string        `json:"name"`
+	Starts      int           `json:"starts"`
+	Failures    int           `json:"failures"`
+	Stalls      int           `json:"stalls"`
+	FirstAudio  time.Duration `json:"first_audio"` // total, divide by Starts
+	Listened    time.Duration `json:"listened"`
+	LastPlayed  time.Time     `json:"last_played"`
+	LastFailure time.Time     `json:"last_failure"`
+	// Failures since the last successful start
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Favorites only, see FavoritesWatch
+	LastProbe     time.Time `json:"last_probe"`
+	ProbeFailures int       `json:"probe_failures,omitempty"`
+}
+
+// Score is between 0 and 1, an unknown station scores 0.5
+func (s StationStats) Score() float64 {
+	score := float64(s.Starts+1) / float64(s.Starts+s.Failures+s.Stalls+2)
+	if s.Starts > 0 && s.FirstAudio/time.Duration(s.Starts) > RELIABILITY_SLOW_START {
+		score /= 2
+	}
+	return score
+}
+
+func (s StationStats) Blocked() bool {
+	return s.ConsecutiveFailures >= RELIABILITY_BLOCK_FAILURES && time.Since(s.LastFailure) < RELIABILITY_BLOCK_FOR
+}
+
+func (s StationStats) Dead() bool {
+	return s.ConsecutiveFailures >= RELIABILITY_DEAD_FAILURES || s.ProbeFailures >= RELIABILITY_DEAD_PROBES
+}
+
+// Reliability keeps StationStats by UUID in `reliability.json`. Changes
+// are written out by Run and Close, not as they happen. All methods are
+// safe to call on nil.
+type Reliability struct {
+	Path    string
+	mu      sync.Mutex
+	stats   map[string]*StationStats
+	dirty   bool // changed since the last Flush
+	done    chan bool
+	stopped sync.Once
+}
+
+func LoadReliability(path string) *Reliability {
+	rel := &Reliability{Path: path, stats: map[string]*StationStats{}, done: make(chan bool)}
+	fileData, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(fileData, &rel.stats); err != nil {
+			fmt.Printf("[RELIABILITY] Ignoring %s: %s\n", path, err)
+			rel.stats = map[string]*StationStats{}
+		}
+	}
+	return rel
+}
+
+func (rel *Reliability) Get(uuid string) StationStats {
+	if rel == nil {
+		return StationStats{}
+	}
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	if stats, ok := rel.stats[uuid]; ok {
+		return *stats
+	}
+	return StationStats{}
+}
+
+// All is a copy of the stats of every station, by UUID
+func (rel *Reliability) All() map[string]StationStats {
+	all := map[string]StationStats{}
+	if rel == nil {
+		return all
+	}
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	for uuid, stats := range rel.stats {
+		all[uuid] = *stats
+	}
+	return all
+}
+
+// update changes the stats of `station`, the next Flush writes them out
+func (rel *Reliability) update(station Station, change func(stats *StationStats)) {
+	if rel == nil || station.UUID == "" {
+		return
+	}
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	stats, ok := rel.stats[station.UUID]
+	if !ok {
+		stats = &StationStats{}
+		rel.stats[station.UUID] = stats
+	}
+	stats.Name = station.Name
+	change(stats)
+	rel.dirty = true
+}
+
+// Run writes out the changes every RELIABILITY_SAVE_INTERVAL until Close
+func (rel *Reliability) Run() {
+	if rel == nil {
+		return
+	}
+	ticker := time.NewTicker(RELIABILITY_SAVE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rel.done:
+			return
+		case <-ticker.C:
+			if err := rel.Flush(); err != nil {
+				fmt.Printf("[RELIABILITY] Failed to save: %s\n", err)
+			}
+		}
+	}
+}
+
+// Flush writes the stats out if they changed
+func (rel *Reliability) Flush() error {
+	if rel == nil {
+		return nil
+	}
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	if !rel.dirty {
+		return nil
+	}
+	fileData, err := json.Marshal(rel.stats)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(rel.Path, fileData); err != nil {
+		return err
+	}
+	rel.dirty = false
+	return nil
+}
+
+// Close stops Run and writes out what is left
+func (rel *Reliability) Close() {
+	if rel == nil {
+		return
+	}
+	rel.stopped.Do(func() { close(rel.done) })
+	if err := rel.Flush(); err != nil {
+		fmt.Printf("[RELIABILITY] Failed to save: %s\n", err)
+	}
+}
+
+// Started records a station that played its first audio after `firstAudio`
+func (rel *Reliability) Started(station Station, firstAudio time.Duration) {
+	rel.update(station, func(stats *StationStats) {
+		stats.Starts++
+		stats.FirstAudio += firstAudio
+		stats.LastPlayed = time.Now()
+		stats.ConsecutiveFailures = 0
+		stats.ProbeFailures = 0
+	})
+}
+
+// Failed records a station that did not start
+func (rel *Reliability) Failed(station Station) {
+	rel.update(station, func(stats *StationStats) {
+		stats.Failures++
+		stats.ConsecutiveFailures++
+		stats.LastFailure = time.Now()
+	})
+}
+
+// Probed records whether a station's stream answered a probe. One that
+// does is alive again, however often it failed to start before.
+func (rel *Reliability) Probed(station Station, alive bool) {
+	rel.update(station, func(stats *StationStats) {
+		stats.LastProbe = time.Now()
+		if alive {
+			stats.ProbeFailures = 0
+			stats.ConsecutiveFailures = 0
+		} else {
+			stats.ProbeFailures++
+		}
+	})
+}
+
+func (rel *Reliability) Stalled(station Station) {
+	rel.update(station, func(stats *StationStats) {
+		stats.Stalls++
+	})
+}
+
+func (rel *Reliability) Listened(station Station, listened time.Duration) {
+	rel.update(station, func(stats *StationStats) {
+		stats.Listened += listened
+	})
+}
+
+// Pick chooses one of `stations`, skipping blocked ones and favouring
+// the ones that start reliably
+func (rel *Reliability) Pick(stations []Station) (Station, bool) {
+	return rel.PickBy(stations, nil)
+}
+
+// PickBy is Pick with a `weight` of its own on top, stations weighing 0
+// are never picked. A nil `weight` weighs every station the same.
+func (rel *Reliability) PickBy(stations []Station, weight func(station Station) float64) (Station, bool) {
+	if len(stations) == 0 {
+		return Station{}, false
+	}
+	if rel == nil && weight == nil {
+		return PickOne(stations), true
+	}
+	weights := make([]float64, len(stations))
+	total := 0.0
+	for i, station := range stations {
+		stats := rel.Get(station.UUID)
+		if rel != nil && stats.Blocked() {
+			continue
+		}
+		weights[i] = stats.Score()
+		if weight != nil {
+			weights[i] *= weight(station)
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return Station{}, false
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		r -= weight
+		if r < 0 {
+			return stations[i], true
+		}
+	}
+	// Rounding left a sliver over, it belongs to the last candidate
+	for i := len(stations) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return stations[i], true
+		}
+	}
+	return Station{}, false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReliability(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reliability.json")
+	rel := LoadReliability(path)
+	for i := 0; i < RELIABILITY_DEAD_FAILURES; i++ {
+		rel.Failed(testStationA)
+	}
+	rel.Started(testStationB, 2*time.Second)
+	rel.Listened(testStationB, time.Minute)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Saved before a flush: %v", err)
+	}
+
+	// Stats survive a restart
+	rel.Close()
+	rel = LoadReliability(path)
+	a := rel.Get(testStationA.UUID)
+	if !a.Blocked() || !a.Dead() {
+		t.Errorf("Expected A to be blocked and dead: %+v", a)
+	}
+	if b := rel.Get(testStationB.UUID); b.Starts != 1 || b.Listened != time.Minute {
+		t.Errorf("Unexpected stats for B: %+v", b)
+	}
+
+	for i := 0; i < 20; i++ {
+		station, ok := rel.Pick([]Station{testStationA, testStationB})
+		if !ok || station.UUID != testStationB.UUID {
+			t.Fatalf("Picked %s", station.Name)
+		}
+	}
+	if _, ok := rel.Pick([]Station{testStationA}); ok {
+		t.Error("Picked a blocked station")
+	}
+
+	// One good start is enough to bring it back
+	rel.Started(testStationA, time.Second)
+	if a := rel.Get(testStationA.UUID); a.Blocked() || a.Dead() {
+		t.Errorf("Expected A to be back: %+v", a)
+	}
+}
+
+func TestRadioSkipsDeadFavorites(t *testing.T) {
+	f := newTestRadio(t)
+	f.Stats = LoadReliability(filepath.Join(t.TempDir(), "reliability.json"))
+	f.play(t, testStationC)
+	for i := 0; i < RELIABILITY_DEAD_FAILURES; i++ {
+		f.Stats.Failed(testStationA)
+	}
+	// B failing keeps C on air, so both favorites are up for grabs each time
+	for i := 0; i < RELIABILITY_DEAD_FAILURES-1; i++ {
+		f.PlayFavorite()
+		req := f.expectTune(t)
+		if req.station.UUID != testStationB.UUID {
+			t.Fatalf("Tuned dead favorite %s", req.station.Name)
+		}
+		req.result <- StationStream{Station: req.station}
+		f.expectState(t, RADIO_PLAYING)
+	}
+}
+
+func TestRadioStepSkipsDeadFavorites(t *testing.T) {
+	f := newTestRadio(t)
+	f.Stats = LoadReliability(filepath.Join(t.TempDir(), "reliability.json"))
+	f.Favorites = []Station{testStationA, testStationB, testStationC}
+	for i := 0; i < RELIABILITY_DEAD_PROBES; i++ {
+		f.Stats.Probed(testStationB, false)
+	}
+	f.play(t, testStationA)
+	f.StepFavorite(1)
+	if req := f.expectTune(t); req.station.UUID != testStationC.UUID {
+		t.Fatalf("Expected to skip dead B for C, got %s", req.station.Name)
+	}
+
+	// One good probe brings B back
+	f.Stats.Probed(testStationB, true)
+	if f.Stats.Get(testStationB.UUID).Dead() {
+		t.Errorf("B is still dead after answering a probe")
+	}
+}
+
+func TestReliabilityFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reliability.json")
+	interval := RELIABILITY_SAVE_INTERVAL
+	RELIABILITY_SAVE_INTERVAL = 10 * time.Millisecond
+	defer func() { RELIABILITY_SAVE_INTERVAL = interval }()
+
+	rel := LoadReliability(path)
+	go rel.Run()
+	defer rel.Close()
+	rel.Started(testStationA, time.Second)
+	deadline := time.Now().Add(time.Second)
+	for LoadReliability(path).Get(testStationA.UUID).Starts != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Stats were not saved")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Nothing changed, nothing to write
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Saved without a change: %v", err)
+	}
+
+	// Close writes out what Run didn't get to
+	rel.Failed(testStationB)
+	rel.Close()
+	rel = LoadReliability(path)
+	if rel.Get(testStationB.UUID).LastFailure.IsZero() || !rel.Get(testStationA.UUID).LastFailure.IsZero() {
+		t.Errorf("Unexpected stats: %+v", rel.All())
+	}
+}
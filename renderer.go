@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/go-pirateaudio/display"
+)
+
+const (
+	DISPLAY_PANEL = "panel"
+	DISPLAY_PNG   = "png"
+	DISPLAY_HTTP  = "http"
+
+	DISPLAY_WIDTH  = 240
+	DISPLAY_HEIGHT = 240
+)
+
+// How often the PNG renderer writes `snapshot.png`
+var SNAPSHOT_INTERVAL = time.Second
+
+// Renderer is where `Display` draws its frames. Images are GIF/PNG
+// encoded, exactly as they are read from `STATUS_IMAGES_PATH`.
+type Renderer interface {
+	DrawImage(r io.Reader)
+	FillScreen(c color.RGBA)
+	Close()
+}
+
+// NewRenderer returns a renderer of `kind`. For `png` target is the
+// snapshot directory, for `http` it is the listen address.
+func NewRenderer(kind string, target string) (Renderer, error) {
+	switch kind {
+	case DISPLAY_PANEL:
+		return NewPanelRenderer()
+	case DISPLAY_PNG:
+		return NewFramebufferRenderer(target)
+	case DISPLAY_HTTP:
+		return NewHTTPRenderer(target)
+	}
+	return nil, fmt.Errorf("unknown display `%s`", kind)
+}
+
+// PanelRenderer draws on the Pirate Audio ST7789
+type PanelRenderer struct {
+	dsp *display.Display
+}
+
+func NewPanelRenderer() (*PanelRenderer, error) {
+	dsp, err := display.Init()
+	if err != nil {
+		return nil, err
+	}
+	return &PanelRenderer{dsp}, nil
+}
+
+func (p *PanelRenderer) DrawImage(r io.Reader) {
+	p.dsp.DrawImage(r)
+}
+
+func (p *PanelRenderer) FillScreen(c color.RGBA) {
+	p.dsp.FillScreen(c)
+}
+
+func (p *PanelRenderer) Close() {
+	p.dsp.Close()
+}
+
+// FramebufferRenderer keeps the last frame in memory. If `Dir` is set,
+// the frame is dumped to `Dir/snapshot.png` at most every SNAPSHOT_INTERVAL.
+type FramebufferRenderer struct {
+	Dir      string
+	mu       sync.Mutex
+	frame    *image.RGBA
+	frameNo  int
+	updated  chan struct{} // closed on the next frame
+	lastDump time.Time
+}
+
+func NewFramebufferRenderer(dir string) (*FramebufferRenderer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	fb := &FramebufferRenderer{
+		Dir:     dir,
+		frame:   image.NewRGBA(image.Rect(0, 0, DISPLAY_WIDTH, DISPLAY_HEIGHT)),
+		updated: make(chan struct{}),
+	}
+	return fb, nil
+}
+
+func (fb *FramebufferRenderer) DrawImage(r io.Reader) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		log.Printf("[DISPLAY] Failed to decode frame: %v", err)
+		return
+	}
+	fb.mu.Lock()
+	draw.Draw(fb.frame, fb.frame.Bounds(), img, img.Bounds().Min, draw.Src)
+	fb.changed()
+	fb.mu.Unlock()
+}
+
+func (fb *FramebufferRenderer) FillScreen(c color.RGBA) {
+	fb.mu.Lock()
+	draw.Draw(fb.frame, fb.frame.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	fb.changed()
+	fb.mu.Unlock()
+}
+
+func (fb *FramebufferRenderer) Close() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.Dir != "" {
+		fb.dump()
+	}
+}
+
+// Snapshot returns a copy of the frame currently on screen
+func (fb *FramebufferRenderer) Snapshot() *image.RGBA {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	img := image.NewRGBA(fb.frame.Bounds())
+	copy(img.Pix, fb.frame.Pix)
+	return img
+}
+
+func (fb *FramebufferRenderer) WritePNG(w io.Writer) error {
+	return png.Encode(w, fb.Snapshot())
+}
+
+// Must be called with fb.mu held
+func (fb *FramebufferRenderer) changed() {
+	fb.frameNo++
+	close(fb.updated)
+	fb.updated = make(chan struct{})
+	if fb.Dir != "" && time.Since(fb.lastDump) >= SNAPSHOT_INTERVAL {
+		fb.dump()
+	}
+}
+
+// Must be called with fb.mu held
+func (fb *FramebufferRenderer) dump() {
+	fb.lastDump = time.Now()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, fb.frame); err != nil {
+		log.Printf("[DISPLAY] Failed to encode snapshot: %v", err)
+		return
+	}
+	// Write then rename so a viewer never sees half a PNG
+	fp := filepath.Join(fb.Dir, "snapshot.png")
+	if err := os.WriteFile(fp+".tmp", buf.Bytes(), 0644); err != nil {
+		log.Printf("[DISPLAY] Failed to write snapshot: %v", err)
+		return
+	}
+	os.Rename(fp+".tmp", fp)
+}
+
+// waitFrame blocks until a frame newer than `after` is drawn and returns
+// its number, or until `ctx` is done, e.g. when the client goes away
+func (fb *FramebufferRenderer) waitFrame(ctx context.Context, after int) (int, bool) {
+	for {
+		fb.mu.Lock()
+		frameNo, updated := fb.frameNo, fb.updated
+		fb.mu.Unlock()
+		if frameNo != after {
+			return frameNo, true
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return after, false
+		}
+	}
+}
+
+// HTTPRenderer serves the framebuffer as an MJPEG stream, so you can
+// see what the box is showing from a browser.
+//
+//	/             preview page
+//	/stream       multipart/x-mixed-replace JPEG stream
+//	/snapshot.png current frame
+type HTTPRenderer struct {
+	*FramebufferRenderer
+	server   *http.Server
+	listener net.Listener
+}
+
+func NewHTTPRenderer(addr string) (*HTTPRenderer, error) {
+	fb, _ := NewFramebufferRenderer("")
+	h := &HTTPRenderer{FramebufferRenderer: fb}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.servePage)
+	mux.HandleFunc("/stream", h.serveStream)
+	mux.HandleFunc("/snapshot.png", h.serveSnapshot)
+	// Listen here, so a port in use fails the start instead of a goroutine
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	h.listener = listener
+	h.server = &http.Server{Handler: mux}
+	go func() {
+		if err := h.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[DISPLAY] Preview server failed: %v", err)
+		}
+	}()
+	fmt.Printf("[DISPLAY] Preview on http://%s/\n", h.Addr())
+	return h, nil
+}
+
+// Addr is where the preview listens, with the port picked for `:0`
+func (h *HTTPRenderer) Addr() string {
+	return h.listener.Addr().String()
+}
+
+func (h *HTTPRenderer) Close() {
+	h.server.Close()
+}
+
+func (h *HTTPRenderer) servePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<html><body style="background:#222;margin:0;display:flex;justify-content:center;align-items:center;height:100vh">`+
+		`<img src="/stream" width="%d" height="%d"></body></html>`, DISPLAY_WIDTH*2, DISPLAY_HEIGHT*2)
+}
+
+func (h *HTTPRenderer) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/png")
+	h.WritePNG(w)
+}
+
+func (h *HTTPRenderer) serveStream(w http.ResponseWriter, r *http.Request) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	frameNo := -1
+	for {
+		var ok bool
+		frameNo, ok = h.waitFrame(r.Context(), frameNo)
+		if !ok {
+			return
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, h.Snapshot(), nil); err != nil {
+			return
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":   {"image/jpeg"},
+			"Content-Length": {fmt.Sprint(buf.Len())},
+		})
+		if err != nil {
+			return
+		}
+		if _, err := buf.WriteTo(part); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testRed = color.RGBA{R: 255, A: 255}
+
+func TestFramebufferSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	fb, err := NewFramebufferRenderer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.FillScreen(color.RGBA{G: 255, A: 255})
+
+	// A half red, half blue PNG, drawn like a status image
+	img := image.NewRGBA(image.Rect(0, 0, DISPLAY_WIDTH, DISPLAY_HEIGHT))
+	for x := 0; x < DISPLAY_WIDTH; x++ {
+		for y := 0; y < DISPLAY_HEIGHT; y++ {
+			img.Set(x, y, testRed)
+			if y >= DISPLAY_HEIGHT/2 {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	fb.DrawImage(&buf)
+	fb.Close()
+
+	fileData, err := os.ReadFile(filepath.Join(dir, "snapshot.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := png.Decode(bytes.NewReader(fileData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(snapshot.At(0, 0)); c != testRed {
+		t.Errorf("Expected red on top, got %v", c)
+	}
+	if c := color.RGBAModel.Convert(snapshot.At(0, DISPLAY_HEIGHT-1)); c != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("Expected blue at the bottom, got %v", c)
+	}
+}
+
+func TestFramebufferWaitFrame(t *testing.T) {
+	fb, _ := NewFramebufferRenderer("")
+	if frameNo, ok := fb.waitFrame(context.Background(), -1); !ok || frameNo != 0 {
+		t.Fatalf("Expected the first frame right away, got %d", frameNo)
+	}
+
+	// A client that goes away stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		_, ok := fb.waitFrame(ctx, 0)
+		done <- ok
+	}()
+	cancel()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("Expected no frame after the cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Still waiting after the cancel")
+	}
+
+	go func() {
+		frameNo, _ := fb.waitFrame(context.Background(), 0)
+		done <- frameNo == 1
+	}()
+	fb.FillScreen(testRed)
+	if ok := <-done; !ok {
+		t.Errorf("Expected frame 1")
+	}
+}
+
+func TestHTTPRenderer(t *testing.T) {
+	h, err := NewHTTPRenderer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.FillScreen(testRed)
+	res, err := http.Get("http://" + h.Addr() + "/snapshot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	snapshot, err := png.Decode(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := color.RGBAModel.Convert(snapshot.At(10, 10)); c != testRed {
+		t.Errorf("Expected red, got %v", c)
+	}
+
+	// The port is taken now
+	if _, err := NewHTTPRenderer(h.Addr()); err == nil {
+		t.Errorf("Expected an error for a port in use")
+	} else if _, ok := err.(*net.OpError); !ok {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
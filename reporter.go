@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// How often queued clicks and votes are sent
+	REPORT_INTERVAL = time.Minute
+	// A report that failed this many times is dropped
+	REPORT_MAX_ATTEMPTS = 5
+	// Reports are dropped oldest first beyond this, e.g. after days offline
+	REPORT_QUEUE_LIMIT = 100
+	// How long Close waits for the last Flush
+	REPORT_CLOSE_TIMEOUT = 3 * time.Second
+)
+
+const (
+	REPORT_CLICK = "click"
+	REPORT_VOTE  = "vote"
+)
+
+type report struct {
+	kind     string
+	station  Station
+	attempts int
+}
+
+// Reporter sends clicks and votes to radio-browser in the background, so
+// playback never waits for it. All methods are safe to call on nil.
+type Reporter struct {
+	Client *RadioBrowser
+
+	mu      sync.Mutex
+	queue   []report
+	done    chan bool
+	stopped sync.Once
+}
+
+func NewReporter(client *RadioBrowser) *Reporter {
+	return &Reporter{
+		Client: client,
+		done:   make(chan bool),
+	}
+}
+
+// Click queues a click for a station that started playing
+func (rep *Reporter) Click(station Station) {
+	rep.add(REPORT_CLICK, station)
+}
+
+// Vote queues a vote for a station that was added to the favorites
+func (rep *Reporter) Vote(station Station) {
+	rep.add(REPORT_VOTE, station)
+}
+
+func (rep *Reporter) add(kind string, station Station) {
+	// radio-browser has never heard of imported stations it didn't know
+	if rep == nil || station.UUID == "" || strings.HasPrefix(station.UUID, LOCAL_UUID_PREFIX) {
+		return
+	}
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	for _, queued := range rep.queue {
+		// radio-browser counts one click per station and address a day anyway
+		if queued.kind == kind && queued.station.UUID == station.UUID {
+			return
+		}
+	}
+	rep.queue = append(rep.queue, report{kind: kind, station: station})
+	if len(rep.queue) > REPORT_QUEUE_LIMIT {
+		rep.queue = rep.queue[len(rep.queue)-REPORT_QUEUE_LIMIT:]
+	}
+}
+
+// Pending is the number of reports waiting to be sent
+func (rep *Reporter) Pending() int {
+	if rep == nil {
+		return 0
+	}
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return len(rep.queue)
+}
+
+// Run sends the queue every REPORT_INTERVAL until Close
+func (rep *Reporter) Run() {
+	if rep == nil {
+		return
+	}
+	ticker := time.NewTicker(REPORT_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rep.done:
+			return
+		case <-ticker.C:
+			rep.Flush()
+		}
+	}
+}
+
+// Flush sends everything queued, and queues the failures again
+func (rep *Reporter) Flush() {
+	if rep == nil {
+		return
+	}
+	rep.mu.Lock()
+	batch := rep.queue
+	rep.queue = nil
+	rep.mu.Unlock()
+
+	retry := []report{}
+	for _, r := range batch {
+		var err error
+		if r.kind == REPORT_VOTE {
+			err = rep.Client.Vote(r.station.UUID)
+		} else {
+			err = rep.Client.Click(r.station.UUID)
+		}
+		if err == nil {
+			fmt.Printf("[REPORT] %s: %s\n", r.kind, r.station.Name)
+			continue
+		}
+		r.attempts++
+		if errors.Is(err, ErrRejected) || r.attempts >= REPORT_MAX_ATTEMPTS {
+			fmt.Printf("[REPORT] Dropped %s for %s: %s\n", r.kind, r.station.Name, err)
+			continue
+		}
+		retry = append(retry, r)
+	}
+	if len(retry) == 0 {
+		return
+	}
+	rep.mu.Lock()
+	rep.queue = append(retry, rep.queue...)
+	rep.mu.Unlock()
+}
+
+// Close stops Run and gives the queue one last go, without holding up the
+// shutdown for more than REPORT_CLOSE_TIMEOUT
+func (rep *Reporter) Close() {
+	if rep == nil {
+		return
+	}
+	rep.stopped.Do(func() {
+		close(rep.done)
+		flushed := make(chan bool)
+		go func() {
+			rep.Flush()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-time.After(REPORT_CLOSE_TIMEOUT):
+			fmt.Println("[REPORT] Gave up on the last reports")
+		}
+	})
+}
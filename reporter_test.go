@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReporter(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	down := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path]++
+		switch {
+		case down:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		case r.URL.Path == "/json/vote/uuid-b":
+			w.Write([]byte(`{"ok": false, "message": "you are voting for the same station too often"}`))
+		default:
+			w.Write([]byte(`{"ok": true, "message": "retrieved station url"}`))
+		}
+	}))
+	defer server.Close()
+
+	rep := NewReporter(newTestRadioBrowser(server))
+	rep.Click(testStationA)
+	rep.Click(testStationA)
+	rep.Vote(testStationA)
+	rep.Vote(testStationB)
+	// Nothing radio-browser could count
+	rep.Click(Station{Name: "Imported", UUID: LOCAL_UUID_PREFIX + "0123"})
+	rep.Vote(Station{Name: "No UUID"})
+	if rep.Pending() != 3 {
+		t.Fatalf("Expected 3 reports queued, got %d", rep.Pending())
+	}
+
+	// Failures stay queued for the next round
+	rep.Flush()
+	if rep.Pending() != 3 {
+		t.Fatalf("Expected 3 reports to retry, got %d", rep.Pending())
+	}
+
+	// A rejected vote is not worth retrying
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	rep.Flush()
+	if rep.Pending() != 0 {
+		t.Errorf("Expected an empty queue, got %d", rep.Pending())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests["/json/url/uuid-a"] != 2 || requests["/json/vote/uuid-a"] != 2 {
+		t.Errorf("Unexpected requests: %v", requests)
+	}
+}
+
+func TestReporterClose(t *testing.T) {
+	sent := make(chan string, 4)
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json/url/uuid-b" {
+			<-release
+		}
+		sent <- r.URL.Path
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// What is queued goes out on the way down
+	rep := NewReporter(newTestRadioBrowser(server))
+	rep.Click(testStationA)
+	rep.Close()
+	select {
+	case path := <-sent:
+		if path != "/json/url/uuid-a" {
+			t.Errorf("Unexpected request %s", path)
+		}
+	default:
+		t.Error("The click was not sent on Close")
+	}
+	rep.Close()
+
+	// But a hanging mirror doesn't hold up the shutdown
+	timeout := REPORT_CLOSE_TIMEOUT
+	REPORT_CLOSE_TIMEOUT = 20 * time.Millisecond
+	defer func() { REPORT_CLOSE_TIMEOUT = timeout }()
+	rep = NewReporter(newTestRadioBrowser(server))
+	rep.Click(testStationB)
+	start := time.Now()
+	rep.Close()
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("Close waited %s", waited)
+	}
+}
+
+func TestReporterNil(t *testing.T) {
+	var rep *Reporter
+	rep.Click(testStationA)
+	rep.Vote(testStationA)
+	rep.Run()
+	rep.Flush()
+	rep.Close()
+	if rep.Pending() != 0 {
+		t.Error("Expected nothing pending")
+	}
+}
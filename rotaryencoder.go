@@ -1,168 +1,273 @@
-//go:build ignore
-
-/* Decording a rotary encoder, but uses periph.io.
-TODO: would be to use go-rpio but we don't have a
-rotary encoder in this project anyway */
-
 package main
 
+/* Decoding a rotary encoder with go-rpio. go-rpio can't block on an
+edge, so both encoders poll their pins instead. */
+
 import (
 	"log"
 	"time"
 
-	"periph.io/x/conn/v3/gpio"
-	"periph.io/x/conn/v3/gpio/gpioreg"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+var (
+	ENC_A = 17
+	ENC_B = 27
+
+	// 8421 rotary switch, most significant bit first
+	DIAL_8 = 4
+	DIAL_4 = 22
+	DIAL_2 = 23
+	DIAL_1 = 26
+)
+
+// Set in `config.json`, not every enclosure has room for them
+var (
+	ENCODER_ENABLED = false
+	DIAL_ENABLED    = false
 )
 
+// How often the encoder pins are sampled. Too slow and fast turns are lost.
+var ENCODER_POLL = time.Millisecond
+
 type State struct {
-	Pin   gpio.PinIO
-	Level gpio.Level
+	Pin   rpio.Pin
+	Level rpio.State
 }
 
 type RotaryEncoder struct {
-	aPin  gpio.PinIO
-	bPin  gpio.PinIO
+	aPin  rpio.Pin
+	bPin  rpio.Pin
 	state [4]State
 
 	cw  [4]State
 	ccw [4]State
+
+	// Receives +1 for every clockwise detent and -1 for counter-clockwise
+	Turn chan int
+	done chan bool
 }
 
 func (t *RotaryEncoder) Init() {
 	t.cw = [4]State{
 		{
 			Pin:   t.aPin,
-			Level: gpio.High,
+			Level: rpio.High,
 		},
 		{
 			Pin:   t.bPin,
-			Level: gpio.High,
+			Level: rpio.High,
 		},
 		{
 			Pin:   t.aPin,
-			Level: gpio.Low,
+			Level: rpio.Low,
 		},
 		{
 			Pin:   t.bPin,
-			Level: gpio.Low,
+			Level: rpio.Low,
 		},
 	}
 
 	t.ccw = [4]State{
 		{
 			Pin:   t.bPin,
-			Level: gpio.High,
+			Level: rpio.High,
 		},
 		{
 			Pin:   t.aPin,
-			Level: gpio.High,
+			Level: rpio.High,
 		},
 		{
 			Pin:   t.bPin,
-			Level: gpio.Low,
+			Level: rpio.Low,
 		},
 		{
 			Pin:   t.aPin,
-			Level: gpio.Low,
+			Level: rpio.Low,
 		},
 	}
 }
 
-func NewRotaryEncoder(pin1 string, pin2 string) *RotaryEncoder {
-	aPin := gpioreg.ByName(pin1)
-	bPin := gpioreg.ByName(pin2)
-	if err := aPin.In(gpio.PullUp, gpio.BothEdges); err != nil {
-		log.Fatal(err)
+// matches is true if the last four states are any rotation of `seq`
+func (t *RotaryEncoder) matches(seq [4]State) bool {
+	for offset := 0; offset < 4; offset++ {
+		ok := true
+		for i := 0; i < 4; i++ {
+			if t.state[i] != seq[(i+offset)%4] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
 	}
-	if err := bPin.In(gpio.PullUp, gpio.BothEdges); err != nil {
-		log.Fatal(err)
+	return false
+}
+
+func (t *RotaryEncoder) push(state State) {
+	if state == t.state[3] {
+		return
+	}
+	t.state = [4]State{
+		t.state[1],
+		t.state[2],
+		t.state[3],
+		state,
+	}
+	steps := 0
+	if t.matches(t.cw) {
+		steps = 1
+	} else if t.matches(t.ccw) {
+		steps = -1
+	}
+	if steps == 0 {
+		return
+	}
+	t.state = [4]State{}
+	// Nobody reading after Close must not keep the poller alive
+	select {
+	case t.Turn <- steps:
+	case <-t.done:
+	}
+}
+
+// NewRotaryEncoder expects rpio to be open
+func NewRotaryEncoder(pin1 int, pin2 int) *RotaryEncoder {
+	aPin := rpio.Pin(pin1)
+	bPin := rpio.Pin(pin2)
+	for _, pin := range []rpio.Pin{aPin, bPin} {
+		pin.Input()
+		pin.PullUp()
 	}
 	t := &RotaryEncoder{
 		aPin: aPin,
 		bPin: bPin,
+		Turn: make(chan int),
+		done: make(chan bool),
 	}
 	t.Init()
-	triggerChan := make(chan gpio.PinIO)
 	go func() {
+		levels := map[rpio.Pin]rpio.State{aPin: aPin.Read(), bPin: bPin.Read()}
+		ticker := time.NewTicker(ENCODER_POLL)
+		defer ticker.Stop()
 		for {
-			pin := <-triggerChan
-			state := State{Level: pin.Read(), Pin: pin}
-			if state == t.state[3] {
-				continue
-			}
-			t.state = [4]State{
-				t.state[1],
-				t.state[2],
-				t.state[3],
-				state,
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
 			}
-			if (t.state[0] == t.cw[0] && t.state[1] == t.cw[1] && t.state[2] == t.cw[2] && t.state[3] == t.cw[3]) || (t.state[0] == t.cw[1] && t.state[1] == t.cw[2] && t.state[2] == t.cw[3] && t.state[3] == t.cw[0]) || (t.state[0] == t.cw[2] && t.state[1] == t.cw[3] && t.state[2] == t.cw[0] && t.state[3] == t.cw[1]) || (t.state[0] == t.cw[3] && t.state[1] == t.cw[0] && t.state[2] == t.cw[1] && t.state[3] == t.cw[2]) {
-				t.state = [4]State{}
-				log.Printf("[%d] [Encoder] CW", time.Now().UnixNano())
-			} else if (t.state[0] == t.ccw[0] && t.state[1] == t.ccw[1] && t.state[2] == t.ccw[2] && t.state[3] == t.ccw[3]) || (t.state[0] == t.ccw[1] && t.state[1] == t.ccw[2] && t.state[2] == t.ccw[3] && t.state[3] == t.ccw[0]) || (t.state[0] == t.ccw[2] && t.state[1] == t.ccw[3] && t.state[2] == t.ccw[0] && t.state[3] == t.ccw[1]) || (t.state[0] == t.ccw[3] && t.state[1] == t.ccw[0] && t.state[2] == t.ccw[1] && t.state[3] == t.ccw[2]) {
-				t.state = [4]State{}
-				log.Printf("[%d] [Encoder] CCW", time.Now().UnixNano())
+			for _, pin := range []rpio.Pin{aPin, bPin} {
+				level := pin.Read()
+				if level != levels[pin] {
+					levels[pin] = level
+					t.push(State{Pin: pin, Level: level})
+				}
 			}
 		}
 	}()
-	for _, pin := range []gpio.PinIO{aPin, bPin} {
-		go func(pin gpio.PinIO) {
-			for {
-				if pin.WaitForEdge(-1) {
-					triggerChan <- pin
-				}
-			}
-		}(pin)
-	}
 	return t
 }
 
+func (t *RotaryEncoder) Close() {
+	close(t.done)
+}
+
 type Rotary8421Encoder struct {
 	State int
+
+	// Receives the switch position (0-15) once it has settled
+	Changed chan int
+	done    chan bool
 }
 
-func New8421Encoder(pin1 string, pin2 string, pin3 string, pin4 string) *Rotary8421Encoder {
-	enc := &Rotary8421Encoder{}
-	s1 := gpioreg.ByName(pin1)
-	s2 := gpioreg.ByName(pin2)
-	s3 := gpioreg.ByName(pin3)
-	s4 := gpioreg.ByName(pin4)
-	triggerChan := make(chan int)
+// New8421Encoder expects rpio to be open. Only turning the dial reports a
+// position, where it sits at boot must not override the resumed station.
+func New8421Encoder(pin1 int, pin2 int, pin3 int, pin4 int) *Rotary8421Encoder {
+	enc := &Rotary8421Encoder{
+		Changed: make(chan int),
+		done:    make(chan bool),
+	}
+	keys := []rpio.Pin{rpio.Pin(pin1), rpio.Pin(pin2), rpio.Pin(pin3), rpio.Pin(pin4)}
+	for _, key := range keys {
+		key.Input()
+		key.PullDown()
+	}
+	read := func() []rpio.State {
+		levels := make([]rpio.State, len(keys))
+		for i, key := range keys {
+			levels[i] = key.Read()
+		}
+		return levels
+	}
+	levels := read()
+	enc.State = dialPosition(levels)
 	debounceDuration := 1250 * time.Millisecond
+	// Armed by the first edge
 	timeout := time.NewTimer(debounceDuration)
+	timeout.Stop()
 	go func() {
 		for {
-			<-timeout.C
-			bools := []gpio.Level{s1.Read(), s2.Read(), s3.Read(), s4.Read()}
-			var number int
-			for i, b := range bools {
-				if b {
-					number |= 1 << (len(bools) - 1 - i)
-				}
+			select {
+			case <-enc.done:
+				return
+			case <-timeout.C:
+			}
+			number := dialPosition(read())
+			if !enc.settle(number) {
+				continue
 			}
-			enc.State = number
 			log.Println("[8421] State: ", number)
+			select {
+			case enc.Changed <- number:
+			case <-enc.done:
+				return
+			}
 		}
 	}()
 	go func() {
 		for {
-			triggerPin := <-triggerChan
-			timeout.Stop()
-			timeout.Reset(debounceDuration)
-			log.Printf("[8421] Triggered: %d", triggerPin)
+			select {
+			case <-enc.done:
+				return
+			default:
+			}
+			for i, key := range keys {
+				level := key.Read()
+				if level != levels[i] {
+					levels[i] = level
+					timeout.Stop()
+					timeout.Reset(debounceDuration)
+					log.Printf("[8421] Triggered: %d", key)
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
 		}
 	}()
-	for _, key := range []gpio.PinIO{s1, s2, s3, s4} {
-		if err := key.In(gpio.PullDown, gpio.RisingEdge); err != nil {
-			log.Fatal(err)
+	return enc
+}
+
+// dialPosition reads the 8421 levels, most significant bit first
+func dialPosition(levels []rpio.State) int {
+	var number int
+	for i, level := range levels {
+		if level == rpio.High {
+			number |= 1 << (len(levels) - 1 - i)
 		}
-		go func(key gpio.PinIO) {
-			for {
-				if key.WaitForEdge(-1) {
-					triggerChan <- key.Number()
-				}
-			}
-		}(key)
 	}
-	return enc
+	return number
+}
+
+// settle is true if the dial came to rest somewhere new. Turned and back
+// again is no change.
+func (enc *Rotary8421Encoder) settle(number int) bool {
+	if number == enc.State {
+		return false
+	}
+	enc.State = number
+	return true
+}
+
+func (enc *Rotary8421Encoder) Close() {
+	close(enc.done)
 }
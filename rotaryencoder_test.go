@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+func newTestEncoder() *RotaryEncoder {
+	t := &RotaryEncoder{
+		aPin: rpio.Pin(ENC_A),
+		bPin: rpio.Pin(ENC_B),
+		Turn: make(chan int, 8),
+		done: make(chan bool),
+	}
+	t.Init()
+	return t
+}
+
+func TestRotaryEncoderDecoding(t *testing.T) {
+	a, b := rpio.Pin(ENC_A), rpio.Pin(ENC_B)
+	for _, test := range []struct {
+		name   string
+		states []State
+		turns  []int
+	}{
+		{"clockwise", []State{{a, rpio.High}, {b, rpio.High}, {a, rpio.Low}, {b, rpio.Low}}, []int{1}},
+		{"counter-clockwise", []State{{b, rpio.High}, {a, rpio.High}, {b, rpio.Low}, {a, rpio.Low}}, []int{-1}},
+		{"clockwise from mid detent", []State{{a, rpio.Low}, {b, rpio.Low}, {a, rpio.High}, {b, rpio.High}}, []int{1}},
+		{"two clockwise", []State{
+			{a, rpio.High}, {b, rpio.High}, {a, rpio.Low}, {b, rpio.Low},
+			{a, rpio.High}, {b, rpio.High}, {a, rpio.Low}, {b, rpio.Low},
+		}, []int{1, 1}},
+		{"clockwise then back", []State{
+			{a, rpio.High}, {b, rpio.High}, {a, rpio.Low}, {b, rpio.Low},
+			{b, rpio.High}, {a, rpio.High}, {b, rpio.Low}, {a, rpio.Low},
+		}, []int{1, -1}},
+		{"bounce", []State{{a, rpio.High}, {a, rpio.High}, {a, rpio.Low}, {a, rpio.High}}, nil},
+		{"half a detent", []State{{a, rpio.High}, {b, rpio.High}}, nil},
+	} {
+		enc := newTestEncoder()
+		for _, state := range test.states {
+			enc.push(state)
+		}
+		close(enc.Turn)
+		turns := []int{}
+		for turn := range enc.Turn {
+			turns = append(turns, turn)
+		}
+		if len(turns) != len(test.turns) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.turns, turns)
+			continue
+		}
+		for i := range turns {
+			if turns[i] != test.turns[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.turns, turns)
+			}
+		}
+	}
+}
+
+func TestRotaryEncoderClosed(t *testing.T) {
+	enc := newTestEncoder()
+	enc.Turn = make(chan int) // nobody reads
+	enc.Close()
+	a, b := rpio.Pin(ENC_A), rpio.Pin(ENC_B)
+	// Must return instead of blocking on the send
+	for _, state := range []State{{a, rpio.High}, {b, rpio.High}, {a, rpio.Low}, {b, rpio.Low}} {
+		enc.push(state)
+	}
+}
+
+func TestRotary8421Encoder(t *testing.T) {
+	low, high := rpio.Low, rpio.High
+	if position := dialPosition([]rpio.State{high, low, high, high}); position != 11 {
+		t.Errorf("Expected 11, got %d", position)
+	}
+	// Sitting at 11 since boot
+	enc := &Rotary8421Encoder{State: 11}
+	if enc.settle(11) {
+		t.Error("Turned and back again is no change")
+	}
+	if !enc.settle(3) || enc.State != 3 {
+		t.Errorf("Expected a change to 3, dial is at %d", enc.State)
+	}
+}
@@ -12,8 +12,7 @@ import (
 
 type AudioSink struct {
 	Analyzer     io.Writer
-	Player       *exec.Cmd
-	PlayerIn     io.Writer
+	Output       AudioOutput
 	Record       bool
 	RecordBuffer io.Writer
 	TempBuffer   bytes.Buffer
@@ -21,8 +20,8 @@ type AudioSink struct {
 	CurrDB       float64
 }
 
-func (sink *AudioSink) Init() {
-	sink.newPlayer()
+func (sink *AudioSink) Init(output AudioOutput) {
+	sink.Output = output
 	analyzer := exec.Command("ffmpeg",
 		"-f", "s16le",
 		"-ar", "44100",
@@ -52,25 +51,8 @@ func (sink *AudioSink) Init() {
 	//analyzer.Start()
 }
 
-func (sink *AudioSink) newPlayer() {
-	if sink.Player != nil {
-		sink.Player.Process.Kill()
-	}
-	aplayCmd := exec.Command("aplay", "-")
-	stdin, err := aplayCmd.StdinPipe()
-	if err != nil {
-		panic(err)
-	}
-	if err := aplayCmd.Start(); err != nil {
-		panic(err)
-	}
-	go aplayCmd.Wait()
-	sink.Player = aplayCmd
-	sink.PlayerIn = stdin
-}
-
 func (sink *AudioSink) Write(b []byte) (n int, err error) {
-	sink.PlayerIn.Write(b)
+	sink.Output.Write(b)
 	//sink.Analyzer.Write(b)
 	sink.LastRead = time.Now()
 	if sink.Record {
@@ -80,7 +62,7 @@ func (sink *AudioSink) Write(b []byte) (n int, err error) {
 }
 
 func (sink *AudioSink) Close() error {
-	return nil
+	return sink.Output.Close()
 }
 
 func (sink *AudioSink) RecordSample() (string, error) {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+var STATE_FILE = "state.json"
+
+// SavedState is what the radio remembers across restarts
+type SavedState struct {
+	Volume int  `json:"volume"`
+	Muted  bool `json:"muted"`
+	// The last station that started playing, resumed at boot
+	Station *Station `json:"station,omitempty"`
+	// The active profile, and the volume the others were left at
+	Profile string                 `json:"profile,omitempty"`
+	Volumes map[string]SavedVolume `json:"volumes,omitempty"`
+}
+
+type SavedVolume struct {
+	Volume int  `json:"volume"`
+	Muted  bool `json:"muted"`
+}
+
+var DEFAULT_STATE = SavedState{
+	Volume: 50,
+}
+
+// StateStore guards `state.json`, several parts of the radio write to it
+type StateStore struct {
+	Path  string
+	mu    sync.Mutex
+	state SavedState
+}
+
+func NewStateStore(path string) *StateStore {
+	store := &StateStore{Path: path, state: DEFAULT_STATE}
+	fileData, err := os.ReadFile(path)
+	if err == nil {
+		state := DEFAULT_STATE
+		if json.Unmarshal(fileData, &state) == nil {
+			store.state = state
+		}
+	}
+	return store
+}
+
+func (store *StateStore) Get() SavedState {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.state
+}
+
+// Update changes the state with `change` and writes it out
+func (store *StateStore) Update(change func(state *SavedState)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	change(&store.state)
+	fileData, err := json.Marshal(store.state)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(store.Path, fileData)
+}
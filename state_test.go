@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := NewStateStore(path)
+	if store.Get().Volume != DEFAULT_STATE.Volume {
+		t.Errorf("Expected the defaults without a file, got %v", store.Get())
+	}
+	err := store.Update(func(state *SavedState) {
+		state.Volume = 30
+		state.Station = &testStationB
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewStateStore(path).Get()
+	if state.Volume != 30 || state.Station == nil || state.Station.UUID != testStationB.UUID {
+		t.Errorf("Unexpected state %v", state)
+	}
+}
@@ -1,45 +1,148 @@
 package main
 
-// API docs: https://de1.api.radio-browser.info
-
 import (
 	"bufio"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
-	"net"
-	"net/http"
+	"net/url"
 	"os"
 	"strings"
-	"time"
+	"sync"
 )
 
 const LANGUAGES_FILE = "languages.txt"
 
 var (
-	BBC_ONE = Station{"BBC One",
-		"0af24a33-1631-4c23-b09a-c1413d2c4fb0",
-		"http://as-hls-ww-live.akamaized.net/pool_904/live/ww/bbc_radio_one/bbc_radio_one.isml/bbc_radio_one-audio%3d96000.norewind.m3u8",
-		"pop",
+	BBC_ONE = Station{
+		Name: "BBC One",
+		UUID: "0af24a33-1631-4c23-b09a-c1413d2c4fb0",
+		URL:  "http://as-hls-ww-live.akamaized.net/pool_904/live/ww/bbc_radio_one/bbc_radio_one.isml/bbc_radio_one-audio%3d96000.norewind.m3u8",
+		Tags: "pop",
 	}
 
 	STATION_SORT_FIELDS = []string{"clickcount", "votes", "clicktrend", "random"}
-	RADIO_SERVERS       = []string{`de1.api.radio-browser.info`, `at1.api.radio-browser.info`, `nl1.api.radio-browser.info`}
 	LANGUAGES           = []string{}
+	SEARCH_FILTERS      = DEFAULT_CONFIG.Search
+
+	// What the last search found, for when the next one comes back empty
+	last_stations_search_results    = []Station{}
+	last_stations_search_results_mu sync.Mutex
 
-	last_search_time             = time.Now()
-	last_stations_search_results = []Station{}
+	languagesMu sync.Mutex
 )
 
+// Languages is a copy of LANGUAGES, safe to shuffle. Switching profiles
+// changes them while searches run.
+func Languages() []string {
+	languagesMu.Lock()
+	defer languagesMu.Unlock()
+	return append([]string{}, LANGUAGES...)
+}
+
+func SetLanguages(languages []string) {
+	languagesMu.Lock()
+	defer languagesMu.Unlock()
+	LANGUAGES = append([]string{}, languages...)
+}
+
 // https://de1.api.radio-browser.info/json/stations/byuuid/0af24a33-1631-4c23-b09a-c1413d2c4fb0
 type Station struct {
-	Name string `json:"name"`
-	UUID string `json:"stationuuid"`
-	URL  string `json:"url_resolved"`
-	Tags string `json:"tags"`
+	Name        string   `json:"name"`
+	UUID        string   `json:"stationuuid"`
+	URL         string   `json:"url_resolved"`
+	URLs        []string `json:"urls,omitempty"` // more to try when URL fails, favorites only
+	Tags        string   `json:"tags"`
+	Language    string   `json:"language,omitempty"` // comma separated
+	Country     string   `json:"country,omitempty"`
+	CountryCode string   `json:"countrycode,omitempty"`
+	Codec       string   `json:"codec,omitempty"`
+	Bitrate     int      `json:"bitrate,omitempty"` // kbps, 0 if unknown
+	Favicon     string   `json:"favicon,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	GeoLat      float64  `json:"geo_lat,omitempty"`
+	GeoLong     float64  `json:"geo_long,omitempty"`
+}
+
+// HasGeo is false for the many stations radio-browser has no location for
+func (station Station) HasGeo() bool {
+	return station.GeoLat != 0 || station.GeoLong != 0
+}
+
+// StreamURLs are the URLs to try in order, URL first
+func (station Station) StreamURLs() []string {
+	return dedupe(append([]string{station.URL}, station.URLs...))
+}
+
+// dedupeStations keeps the first station of every UUID, so one found twice
+// isn't twice as likely to be picked
+func dedupeStations(stations []Station) []Station {
+	seen := map[string]bool{}
+	result := []Station{}
+	for _, station := range stations {
+		if station.UUID != "" && seen[station.UUID] {
+			continue
+		}
+		seen[station.UUID] = true
+		result = append(result, station)
+	}
+	return result
+}
+
+// Matches does everything the API would do with `filters`, for stations
+// that didn't come from a search
+func (f SearchFilters) Matches(station Station) bool {
+	if !f.Allows(station) {
+		return false
+	}
+	if len(f.Tags) > 0 && !hasAny(station.Tags, f.Tags) {
+		return false
+	}
+	if len(f.Countries) > 0 && !hasAny(station.CountryCode, f.Countries) {
+		return false
+	}
+	return true
+}
+
+// hasAny checks if the comma separated `list` has any of `values`
+func hasAny(list string, values []string) bool {
+	for _, item := range strings.Split(list, ",") {
+		for _, value := range values {
+			if strings.EqualFold(strings.TrimSpace(item), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SearchFilters narrow down what a random search can land on. The API takes
+// one tag and one country per search, every search picks one at random.
+type SearchFilters struct {
+	Tags         []string `json:"tags"`
+	ExcludeTags  []string `json:"exclude_tags"`
+	Countries    []string `json:"countries"` // ISO 3166-1 codes, e.g. `GB`
+	Codec        string   `json:"codec"`     // e.g. `MP3` or `AAC`
+	MinBitrate   int      `json:"min_bitrate"`
+	HTTPSOnly    bool     `json:"https_only"`
+	HideBroken   bool     `json:"hide_broken"`
+	ExtendedInfo bool     `json:"has_extended_info"`
+}
+
+// Allows checks what the API can't filter out for us, and results
+// kept from an earlier search with other filters
+func (f SearchFilters) Allows(station Station) bool {
+	if f.MinBitrate > 0 && station.Bitrate < f.MinBitrate {
+		return false
+	}
+	if f.HTTPSOnly && !strings.HasPrefix(station.URL, "https://") {
+		return false
+	}
+	if f.Codec != "" && !strings.EqualFold(station.Codec, f.Codec) {
+		return false
+	}
+	return !hasAny(station.Tags, f.ExcludeTags)
 }
 
 func get_languages_from_file() error {
@@ -69,128 +172,133 @@ func get_languages_from_file() error {
 	return nil
 }
 
-func get_radio_servers() error {
-	service := "api"
-	protocol := "tcp"
-	domain := "radio-browser.info"
-	// Perform DNS SRV lookup
-	_, srvRecords, err := net.LookupSRV(service, protocol, domain)
-	if err != nil || len(srvRecords) == 0 {
-		return fmt.Errorf("Error during SRV lookup: %v\n", err)
+func station_search_query(limit int, sortfield string, language string, filters SearchFilters) url.Values {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprint(limit))
+	query.Set("order", sortfield)
+	if language != "" {
+		query.Set("language", language)
 	}
-	for _, srv := range srvRecords {
-		// srv.Target looks like `de1.api.radio-browser.info.`
-		RADIO_SERVERS = append(RADIO_SERVERS, srv.Target[:len(srv.Target)-1])
+	if len(filters.Tags) > 0 {
+		query.Set("tag", PickOne(filters.Tags))
+	}
+	if len(filters.Countries) > 0 {
+		query.Set("countrycode", strings.ToUpper(PickOne(filters.Countries)))
+	}
+	if filters.Codec != "" {
+		query.Set("codec", filters.Codec)
+	}
+	if filters.MinBitrate > 0 {
+		query.Set("bitrateMin", fmt.Sprint(filters.MinBitrate))
+	}
+	if filters.HTTPSOnly {
+		query.Set("is_https", "true")
+	}
+	if filters.HideBroken {
+		query.Set("hidebroken", "true")
+	}
+	if filters.ExtendedInfo {
+		query.Set("has_extended_info", "true")
 	}
-	return nil
-}
-
-func parse_query_url(stationURL string, limit int, sortfield string, language string) string {
-	s := fmt.Sprintf("https://%s/json/stations/search?", stationURL)
-	s += fmt.Sprintf("limit=%d&", limit)
-	s += fmt.Sprintf("order=%s&", sortfield)
-	s += fmt.Sprintf("language=%s&", language)
 	if rand.Float64() < 0.5 {
-		s += "&reverse=true"
+		query.Set("reverse", "true")
 	}
-	return s
+	return query
 }
 
 func get_station_by_uuid(uuid string) (Station, error) {
-	res, err := http.Get("https://" + PickOne(RADIO_SERVERS) + ".api.radio-browser.info/json/stations/byuuid/" + uuid)
-	if err != nil {
-		return Station{}, err
-	}
-	defer res.Body.Close()
-	stations := json_to_stations(res)
-	if err != nil || len(stations) == 0 {
-		return Station{}, errors.New("No station matching UUID: " + uuid)
-	}
-	return stations[0], nil
+	return RADIO_BROWSER.StationByUUID(uuid)
 }
 
+// get_random_station picks from the catalog when it can, it answers
+// instantly and keeps working without the API. Now and then a search tops
+// the catalog up, so it gets the variety of STATION_SORT_FIELDS too.
 func get_random_station(currentStation Station) (Station, error) {
-
-	stationsResult := make(chan []Station)
-
-	selectedLanguages := []string{}
-	Shuffle(LANGUAGES)
-	if len(LANGUAGES) > 3 {
-		selectedLanguages = LANGUAGES[:3]
-	} else {
-		for len(selectedLanguages) < len(RADIO_SERVERS) {
-			selectedLanguages = append(selectedLanguages, PickOne(LANGUAGES))
-		}
-	}
-
-	for i, language := range selectedLanguages {
-		go func(i int, language string) {
-			server := RADIO_SERVERS[i]
-			query_url := parse_query_url(
-				server,
-				10,
-				PickOne(STATION_SORT_FIELDS),
-				language)
-			stations, err := search_stations(query_url)
-			if err != nil {
-				log.Printf("[%s] Failed: %s", server, err)
+	if CATALOG != nil {
+		station, err := CATALOG.Random(currentStation, Languages(), SEARCH_FILTERS)
+		if err == nil {
+			if CATALOG.TopUpDue() {
+				go search_stations()
 			}
-			stationsResult <- stations
-		}(i, language)
-	}
-
-	stationResults := []Station{}
-
-	for i := 0; i < len(RADIO_SERVERS); i++ {
-		stations := <-stationsResult
-		if len(stations) > 0 {
-			stationResults = append(stationResults, stations...)
+			return station, nil
 		}
 	}
 
+	stationResults, searchErr := search_stations()
+	// The radio may run more than one search at a time
+	last_stations_search_results_mu.Lock()
 	if len(stationResults) == 0 {
-		if len(last_stations_search_results) == 0 {
-			return Station{}, errors.New("No stations returned from search")
-		}
 		stationResults = last_stations_search_results
 	} else {
-		last_search_time = time.Now()
 		last_stations_search_results = stationResults
 	}
+	last_stations_search_results_mu.Unlock()
+	if len(stationResults) == 0 {
+		if searchErr != nil {
+			return Station{}, searchErr
+		}
+		return Station{}, errors.New("No stations returned from search")
+	}
 
-	Shuffle(stationResults)
-
+	candidates := []Station{}
 	for _, station := range stationResults {
-		if station.UUID != currentStation.UUID {
-			return station, nil
+		if station.UUID != currentStation.UUID && SEARCH_FILTERS.Allows(station) {
+			candidates = append(candidates, station)
 		}
 	}
+	if station, ok := RELIABILITY.Pick(candidates); ok {
+		return station, nil
+	}
 
 	return Station{}, errors.New("Failed to get random station")
 
 }
 
-func search_stations(query_url string) ([]Station, error) {
-	// if time.Since(last_search_time) < 60*time.Second && len(last_stations_search_results) > 0 {
-	// 	return last_stations_search_results, nil
-	// }
-	var stations []Station
+// search_stations searches up to three of our languages, sorted by a random
+// field, and adds what it finds to the catalog
+func search_stations() ([]Station, error) {
 
-	resp, err := http.Get(query_url)
-	if err != nil {
-		return nil, err
+	type searchResult struct {
+		stations []Station
+		err      error
 	}
-	stations = json_to_stations(resp)
-	if len(stations) == 0 {
-		return nil, errors.New("No stations found")
+	stationsResult := make(chan searchResult)
+
+	selectedLanguages := Languages()
+	Shuffle(selectedLanguages)
+	if len(selectedLanguages) > 3 {
+		selectedLanguages = selectedLanguages[:3]
+	}
+
+	for _, language := range selectedLanguages {
+		go func(language string) {
+			query := station_search_query(10, PickOne(STATION_SORT_FIELDS), language, SEARCH_FILTERS)
+			stations, err := RADIO_BROWSER.Search(query)
+			if err != nil {
+				log.Printf("[%s] Failed: %s", language, err)
+			}
+			allowed := []Station{}
+			for _, station := range stations {
+				if SEARCH_FILTERS.Allows(station) {
+					allowed = append(allowed, station)
+				}
+			}
+			if CATALOG != nil && len(allowed) > 0 {
+				CATALOG.Add(language, allowed)
+			}
+			stationsResult <- searchResult{allowed, err}
+		}(language)
 	}
-	return stations, nil
-}
 
-func json_to_stations(resp *http.Response) []Station {
-	defer resp.Body.Close()
-	bytes, _ := io.ReadAll(resp.Body)
-	stations := []Station{}
-	json.Unmarshal(bytes, &stations)
-	return stations
+	stationResults := []Station{}
+	var searchErr error
+
+	for range selectedLanguages {
+		result := <-stationsResult
+		stationResults = append(stationResults, result.stations...)
+		if result.err != nil {
+			searchErr = result.err
+		}
+	}
+	return stationResults, searchErr
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSearchQuery(t *testing.T) {
+	filters := SearchFilters{
+		Tags:       []string{"jazz"},
+		Countries:  []string{"gb"},
+		Codec:      "AAC",
+		MinBitrate: 128,
+		HTTPSOnly:  true,
+		HideBroken: true,
+	}
+	query := station_search_query(10, "votes", "english", filters)
+	expected := map[string]string{
+		"limit":       "10",
+		"order":       "votes",
+		"language":    "english",
+		"tag":         "jazz",
+		"countrycode": "GB",
+		"codec":       "AAC",
+		"bitrateMin":  "128",
+		"is_https":    "true",
+		"hidebroken":  "true",
+	}
+	for key, value := range expected {
+		if query.Get(key) != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, query.Get(key))
+		}
+	}
+	if query.Has("has_extended_info") {
+		t.Error("has_extended_info is off")
+	}
+}
+
+func TestSearchFiltersAllows(t *testing.T) {
+	filters := SearchFilters{MinBitrate: 96, ExcludeTags: []string{"Talk"}}
+	tests := []struct {
+		station Station
+		allowed bool
+	}{
+		{Station{Bitrate: 128, Tags: "pop,rock"}, true},
+		{Station{Bitrate: 32, Tags: "pop"}, false},
+		{Station{Bitrate: 0, Tags: "pop"}, false},
+		{Station{Bitrate: 128, Tags: "news, talk"}, false},
+		{Station{Bitrate: 128, Tags: "talkative dj"}, true},
+	}
+	for _, test := range tests {
+		if filters.Allows(test.station) != test.allowed {
+			t.Errorf("Allows(%+v) should be %v", test.station, test.allowed)
+		}
+	}
+}
+
+func TestSearchRandomStation(t *testing.T) {
+	const (
+		UP = iota
+		DOWN
+		WITH_C
+	)
+	var mode, requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch atomic.LoadInt32(&mode) {
+		case DOWN:
+			http.Error(w, "down", http.StatusBadGateway)
+		case WITH_C:
+			w.Write([]byte(`[{"name": "C", "stationuuid": "uuid-c"}]`))
+		default:
+			w.Write([]byte(`[{"name": "A", "stationuuid": "uuid-a"}, {"name": "B", "stationuuid": "uuid-b"}]`))
+		}
+	}))
+	defer server.Close()
+	defer func(rb *RadioBrowser, languages []string, results []Station, catalog *Catalog) {
+		RADIO_BROWSER, LANGUAGES, last_stations_search_results, CATALOG = rb, languages, results, catalog
+	}(RADIO_BROWSER, LANGUAGES, last_stations_search_results, CATALOG)
+	RADIO_BROWSER = newTestRadioBrowser(server)
+	LANGUAGES = []string{"english", "swedish"}
+	last_stations_search_results = []Station{}
+	CATALOG = newTestCatalog(t)
+
+	// An empty catalog searches. The radio can have more than one search going.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if station, err := get_random_station(testStationA); err != nil || station.UUID != testStationB.UUID {
+				t.Errorf("Expected B, got %v %v", station, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if CATALOG.Len() != 4 {
+		t.Errorf("Expected the search results in the catalog, got %d stations", CATALOG.Len())
+	}
+
+	// The catalog answers without the API
+	atomic.StoreInt32(&mode, DOWN)
+	atomic.StoreInt32(&requests, 0)
+	if station, err := get_random_station(testStationB); err != nil || station.UUID != testStationA.UUID {
+		t.Errorf("Expected A from the catalog, got %v %v", station, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("Expected no requests, got %d", n)
+	}
+
+	// A top up searches in the background
+	atomic.StoreInt32(&mode, WITH_C)
+	CATALOG.mu.Lock()
+	CATALOG.toppedUp = time.Time{}
+	CATALOG.mu.Unlock()
+	if station, err := get_random_station(testStationB); err != nil || station.UUID != testStationA.UUID {
+		t.Errorf("Expected A from the catalog, got %v %v", station, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for CATALOG.Len() != 6 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if CATALOG.Len() != 6 {
+		t.Errorf("Expected C added by the top up, got %d stations", CATALOG.Len())
+	}
+	// Only once per CATALOG_TOP_UP_INTERVAL
+	if CATALOG.TopUpDue() {
+		t.Error("Expected no top up right after one")
+	}
+
+	// With no catalog and no API, the last results
+	atomic.StoreInt32(&mode, DOWN)
+	CATALOG = newTestCatalog(t)
+	if station, err := get_random_station(testStationC); err != nil || station.UUID == testStationC.UUID {
+		t.Errorf("Expected A or B from the last search, got %v %v", station, err)
+	}
+	last_stations_search_results = []Station{}
+	if _, err := get_random_station(testStationC); err == nil {
+		t.Error("Expected an error with nothing to fall back on")
+	}
+}
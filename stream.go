@@ -11,18 +11,16 @@ import (
 )
 
 type Buff struct {
-	FirstChunk      bool
-	Sink            *AudioSink
-	PreviousStation *StationStream
-	Failtimer       *time.Timer
-	DataStarted     chan bool
-	LastRead        time.Time
+	FirstChunk  bool
+	Sink        *AudioSink
+	Failtimer   *time.Timer
+	DataStarted chan bool
+	LastRead    time.Time
 }
 
 func (buff *Buff) Write(b []byte) (n int, err error) {
 	if buff.FirstChunk {
 		buff.Failtimer.Stop()
-		buff.PreviousStation.Stop()
 		buff.FirstChunk = false
 		buff.DataStarted <- true
 	}
@@ -38,9 +36,13 @@ type StationStream struct {
 	FFMessages    io.ReadCloser
 	CancelMonitor context.CancelFunc
 	Started       bool
+	StartedIn     time.Duration // time to first audio
+	StreamURL     string        // the one that played, after playlists and redirects
 }
 
-func (stream *StationStream) Monitor(playRandom chan bool, display *Display) {
+// Monitor watches the stream until it is stopped, or calls `stalled` when
+// it runs dry or goes quiet for too long
+func (stream *StationStream) Monitor(stalled func(), display *Display) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -108,7 +110,7 @@ monitorLoop:
 	}
 
 	cancel()
-	playRandom <- true
+	stalled()
 
 }
 
@@ -116,22 +118,63 @@ func (stream *StationStream) Stop() {
 	if stream.CancelMonitor != nil {
 		stream.CancelMonitor()
 	}
-	stream.Process.Process.Kill()
+	if stream.Process != nil && stream.Process.Process != nil {
+		stream.Process.Process.Kill()
+	}
 }
 
-func NewStationStream(station Station, sink *AudioSink, prevStation *StationStream, result chan StationStream) {
+// How long a station gets to start, across all of its stream URLs, and
+// how long a single URL gets when there are more to try
+var (
+	STREAM_START_TIMEOUT     = 30 * time.Second
+	STREAM_CANDIDATE_TIMEOUT = 15 * time.Second
+)
+
+func NewStationStream(station Station, sink *AudioSink, result chan StationStream) {
 	fmt.Printf("[ GET ]: %s\n", station.Name)
+	tuneStart := time.Now()
+	deadline := tuneStart.Add(STREAM_START_TIMEOUT)
+
+	candidates := []string{}
+	for _, stationURL := range station.StreamURLs() {
+		candidates = append(candidates, ResolveStreamURLs(stationURL)...)
+	}
+	candidates = dedupe(candidates)
+
+	for i, streamURL := range candidates {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		if i < len(candidates)-1 && wait > STREAM_CANDIDATE_TIMEOUT {
+			wait = STREAM_CANDIDATE_TIMEOUT
+		}
+		if len(candidates) > 1 {
+			fmt.Printf("[STREAM] [%d/%d] %s\n", i+1, len(candidates), streamURL)
+		}
+		stream := startStream(station, streamURL, sink, wait)
+		if stream.Started {
+			stream.StartedIn = time.Since(tuneStart)
+			result <- stream
+			return
+		}
+	}
+	result <- StationStream{Station: station}
+}
+
+// startStream runs ffmpeg on `streamURL` until the first audio arrives,
+// ffmpeg gives up, or `wait` is over
+func startStream(station Station, streamURL string, sink *AudioSink, wait time.Duration) StationStream {
 	buff := &Buff{
-		true,
-		sink,
-		prevStation,
-		time.NewTimer(30 * time.Second), // How long to wait for this station to start before aborting
-		make(chan bool),
-		time.Now(),
+		FirstChunk:  true,
+		Sink:        sink,
+		Failtimer:   time.NewTimer(wait), // How long to wait for this station to start before aborting
+		DataStarted: make(chan bool, 1),
+		LastRead:    time.Now(),
 	}
 	ffmpegCmd := exec.Command("ffmpeg", "-hide_banner",
-		"-i", station.URL,
-		"-f", "wav",
+		"-i", streamURL,
+		"-f", "s16le", // Raw PCM, every AudioOutput knows the format
 		"-af", "loudnorm=I=-14:LRA=7:TP=-2",
 		"-af", "silencedetect=noise=-30dB:d=20", // Detect silence -30dB, trigger `silence_detected` after 20 seconds
 		"-ar", "44100",
@@ -139,34 +182,42 @@ func NewStationStream(station Station, sink *AudioSink, prevStation *StationStre
 		"-")
 	ffmpegOut, err := ffmpegCmd.StdoutPipe()
 	if err != nil {
-		panic(err)
+		buff.Failtimer.Stop()
+		fmt.Printf("[STREAM] %s\n", err)
+		return StationStream{Station: station}
 	}
 	ffmpegErr, _ := ffmpegCmd.StderrPipe()
-	if err := ffmpegCmd.Start(); err != nil {
-		panic(err)
+	// Out of memory or processes, the next station may be luckier
+	if err := CHILDREN.Start(ffmpegCmd); err != nil {
+		buff.Failtimer.Stop()
+		fmt.Printf("[FFMPEG] Failed to start: %s\n", err)
+		return StationStream{Station: station}
 	}
-	go ffmpegCmd.Wait()
+	ended := make(chan bool)
 	go func() {
 		_, err := io.Copy(buff, ffmpegOut)
 		if err != nil {
 			// Happens when we kill ffmpeg deliberately, or, when something craps out with the stream
 			fmt.Printf("[STREAM] ended: %s\n", station.Name)
 		}
+		close(ended)
 	}()
 	stationProcess := StationStream{
-		station,
-		buff,
-		ffmpegCmd,
-		ffmpegErr,
-		nil,
-		false}
+		Station:    station,
+		StreamURL:  streamURL,
+		Buff:       buff,
+		Process:    ffmpegCmd,
+		FFMessages: ffmpegErr,
+	}
 	select {
 	case <-buff.DataStarted:
 		fmt.Printf("[STREAM] started: %s\n", station.Name)
 		stationProcess.Started = true
-		result <- stationProcess
+	case <-ended:
+		// ffmpeg gave up before playing anything, no point waiting
+		buff.Failtimer.Stop()
 	case <-buff.Failtimer.C:
 		ffmpegCmd.Process.Kill()
-		result <- stationProcess
 	}
+	return stationProcess
 }
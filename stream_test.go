@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartStreamWithoutFFmpeg(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	stream := startStream(testStationA, testStationA.URL, nil, time.Second)
+	if stream.Started || stream.UUID != testStationA.UUID {
+		t.Errorf("Expected A not started, got %v", stream)
+	}
+}
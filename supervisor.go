@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CHILDREN tracks every long running process we start, so none of them
+// outlive the radio
+var CHILDREN = &Children{procs: map[*exec.Cmd]bool{}}
+
+type Children struct {
+	mu    sync.Mutex
+	procs map[*exec.Cmd]bool
+}
+
+// Start starts `cmd` and reaps it in the background once it exits
+func (c *Children) Start(cmd *exec.Cmd) error {
+	setParentDeathSignal(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.procs[cmd] = true
+	c.mu.Unlock()
+	go func() {
+		cmd.Wait()
+		c.mu.Lock()
+		delete(c.procs, cmd)
+		c.mu.Unlock()
+	}()
+	return nil
+}
+
+func (c *Children) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.procs)
+}
+
+// Stop sends SIGTERM to every child, and SIGKILL to the ones still
+// around after `timeout`
+func (c *Children) Stop(timeout time.Duration) {
+	c.signal(syscall.SIGTERM)
+	deadline := time.Now().Add(timeout)
+	for c.Count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if c.Count() > 0 {
+		fmt.Printf("[SHUTDOWN] Killing %d children\n", c.Count())
+		c.signal(syscall.SIGKILL)
+	}
+}
+
+func (c *Children) signal(sig syscall.Signal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cmd := range c.procs {
+		cmd.Process.Signal(sig)
+	}
+}
+
+// Don't restart a dead process more often than this
+var SUPERVISOR_BACKOFF = 2 * time.Second
+
+// Supervisor restarts the process behind a writer, e.g. aplay or amixer,
+// when writing to it fails. While it waits to restart, writes are dropped.
+type Supervisor struct {
+	name      string
+	start     func() (io.WriteCloser, error)
+	mu        sync.Mutex
+	current   io.WriteCloser
+	lastStart time.Time
+	closed    bool
+}
+
+func NewSupervisor(name string, start func() (io.WriteCloser, error)) (*Supervisor, error) {
+	s := &Supervisor{name: name, start: start}
+	current, err := start()
+	if err != nil {
+		return nil, err
+	}
+	s.current = current
+	s.lastStart = time.Now()
+	return s, nil
+}
+
+func (s *Supervisor) Name() string {
+	return s.name
+}
+
+func (s *Supervisor) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if s.current == nil {
+		if time.Since(s.lastStart) < SUPERVISOR_BACKOFF {
+			return len(b), nil
+		}
+		s.lastStart = time.Now()
+		current, err := s.start()
+		if err != nil {
+			fmt.Printf("[SUPERVISOR] Failed to restart %s: %s\n", s.name, err)
+			return len(b), nil
+		}
+		fmt.Printf("[SUPERVISOR] Restarted %s\n", s.name)
+		s.current = current
+	}
+	n, err := s.current.Write(b)
+	if err != nil {
+		fmt.Printf("[SUPERVISOR] %s died: %s\n", s.name, err)
+		s.current.Close()
+		s.current = nil
+		// The caller has nothing better to do with the audio either
+		return len(b), nil
+	}
+	return n, nil
+}
+
+func (s *Supervisor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.current == nil {
+		return nil
+	}
+	return s.current.Close()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Volume change for one step, in percent
+var VOLUME_STEP = 5
+
+// How long the volume has to stay put before it is written to disk
+var VOLUME_SAVE_DELAY = 3 * time.Second
+
+// Volume drives the `Master` control through a long running `amixer -s`.
+// Muting remembers the level so unmuting goes back to it.
+type Volume struct {
+	Display  *Display
+	State    *StateStore
+	Muted    func(muted bool) // told on every change, nil for nobody
+	level    int
+	muted    bool
+	mixer    io.WriteCloser
+	save     *time.Timer
+	commands chan func()
+}
+
+func NewVolume(display *Display, state *StateStore, muted func(muted bool)) *Volume {
+	saved := state.Get()
+	v := &Volume{
+		Display:  display,
+		State:    state,
+		Muted:    muted,
+		level:    saved.Volume,
+		muted:    saved.Muted,
+		commands: make(chan func(), 16),
+	}
+	mixer, err := NewSupervisor("amixer", func() (io.WriteCloser, error) {
+		return NewCommandOutput("amixer", "amixer", "-s")
+	})
+	if err != nil {
+		fmt.Printf("[VOLUME] No mixer: %s\n", err)
+		v.mixer = NullOutput{}
+	} else {
+		v.mixer = mixer
+	}
+	v.save = time.AfterFunc(VOLUME_SAVE_DELAY, func() { v.do(v.persist) })
+	v.save.Stop()
+	v.apply()
+	fmt.Printf("[VOLUME] %d%% muted: %t\n", v.level, v.muted)
+	go func() {
+		for command := range v.commands {
+			command()
+		}
+	}()
+	return v
+}
+
+func (v *Volume) do(command func()) {
+	v.commands <- command
+}
+
+func (v *Volume) ToggleMute() {
+	v.do(func() {
+		v.muted = !v.muted
+		fmt.Printf("[VOLUME] Muted: %t\n", v.muted)
+		v.changed()
+	})
+}
+
+// Step moves the volume by `steps` times VOLUME_STEP, unmuting on the way
+func (v *Volume) Step(steps int) {
+	v.do(func() {
+		v.level += steps * VOLUME_STEP
+		if v.level < 0 {
+			v.level = 0
+		}
+		if v.level > 100 {
+			v.level = 100
+		}
+		v.muted = false
+		fmt.Printf("[VOLUME] %d%%\n", v.level)
+		v.changed()
+	})
+}
+
+func (v *Volume) changed() {
+	v.apply()
+	v.Display.ShowVolume <- VolumeLevel{v.level, v.muted}
+	v.save.Reset(VOLUME_SAVE_DELAY)
+}
+
+func (v *Volume) apply() {
+	level := v.level
+	if v.muted {
+		level = 0
+	}
+	fmt.Fprintf(v.mixer, "set Master %d%%\n", level)
+	if v.Muted != nil {
+		v.Muted(v.muted)
+	}
+}
+
+func (v *Volume) persist() {
+	err := v.State.Update(func(state *SavedState) {
+		state.Volume = v.level
+		state.Muted = v.muted
+	})
+	if err != nil {
+		fmt.Printf("[VOLUME] Failed to save: %s\n", err)
+	}
+}
+
+// SwitchProfile puts the volume of profile `from` aside, and goes to the
+// one profile `to` was left at
+func (v *Volume) SwitchProfile(from string, to string) {
+	v.do(func() {
+		v.save.Stop()
+		err := v.State.Update(func(state *SavedState) {
+			if state.Volumes == nil {
+				state.Volumes = map[string]SavedVolume{}
+			}
+			state.Volumes[from] = SavedVolume{Volume: v.level, Muted: v.muted}
+			saved, ok := state.Volumes[to]
+			if !ok {
+				saved = SavedVolume{Volume: DEFAULT_STATE.Volume}
+			}
+			delete(state.Volumes, to)
+			v.level, v.muted = saved.Volume, saved.Muted
+			state.Volume, state.Muted = v.level, v.muted
+		})
+		if err != nil {
+			fmt.Printf("[VOLUME] Failed to save: %s\n", err)
+		}
+		fmt.Printf("[VOLUME] %d%% muted: %t\n", v.level, v.muted)
+		v.apply()
+	})
+}
+
+// Close writes out a pending volume change and stops amixer
+func (v *Volume) Close() {
+	done := make(chan bool)
+	v.do(func() {
+		if v.save.Stop() {
+			v.persist()
+		}
+		v.mixer.Close()
+		done <- true
+	})
+	<-done
+}